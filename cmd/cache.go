@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tanq16/ai-context/internal/aicontext"
+	"github.com/tanq16/ai-context/utils"
+)
+
+var pruneOlderThan time.Duration
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache of cloned repositories.",
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List cached repository checkouts.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := aicontext.CacheEntries()
+		if err != nil {
+			utils.PrintFatal("failed to list cache", err)
+		}
+		if len(entries) == 0 {
+			utils.PrintInfo("Cache is empty")
+			return
+		}
+		for _, entry := range entries {
+			history := ""
+			if entry.FullHistory {
+				history = " (full history)"
+			}
+			utils.PrintGeneric(fmt.Sprintf("%s@%s%s  %s  last used %s", entry.Repo, entry.Ref, history, entry.HumanSize, entry.LastUsed.Format(time.DateTime)))
+		}
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached checkouts that have not been used recently.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		pruned, err := aicontext.PruneCache(pruneOlderThan)
+		if err != nil {
+			utils.PrintFatal("failed to prune cache", err)
+		}
+		for _, entry := range pruned {
+			utils.PrintIndentedSuccess(fmt.Sprintf("Removed %s@%s (%s)", entry.Repo, entry.Ref, entry.HumanSize))
+		}
+		utils.PrintSuccess(fmt.Sprintf("Pruned %d cached checkouts", len(pruned)))
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove all cached checkouts.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := aicontext.ClearCache(); err != nil {
+			utils.PrintFatal("failed to clear cache", err)
+		}
+		utils.PrintSuccess("Cache cleared")
+	},
+}
+
+func init() {
+	cachePruneCmd.Flags().DurationVar(&pruneOlderThan, "older-than", 30*24*time.Hour, "Remove checkouts not used for this long")
+	cacheCmd.AddCommand(cacheLsCmd, cachePruneCmd, cacheClearCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
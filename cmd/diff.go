@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/tanq16/ai-context/internal/aicontext"
+	"github.com/tanq16/ai-context/utils"
+)
+
+var diffFlags struct {
+	base       string
+	neighbours bool
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [path]",
+	Short: "Produce context for the changes between a base revision and HEAD of a local repository.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := "."
+		if len(args) > 0 {
+			path = args[0]
+		}
+		config := processorConfig(cmd)
+		utils.PrintRunning("Collecting changes")
+		if err := aicontext.HandleDiff(path, diffFlags.base, diffFlags.neighbours, config, cmdFlags.output); err != nil {
+			utils.ClearLines(1)
+			utils.PrintFatal("failed to produce diff context", err)
+		}
+		utils.ClearLines(1)
+		utils.PrintSuccess("Completed all operations successfully")
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringVar(&diffFlags.base, "base", "", "Branch, tag or commit to compare HEAD with (required)")
+	diffCmd.Flags().BoolVar(&diffFlags.neighbours, "neighbours", false, "Also include the other files in the directories of changed files")
+	diffCmd.MarkFlagRequired("base")
+	addProcessingFlags(diffCmd)
+	rootCmd.AddCommand(diffCmd)
+}
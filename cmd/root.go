@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"regexp"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
@@ -25,6 +27,37 @@ var cmdFlags struct {
 	includeGlobs []string
 	excludeGlobs []string
 	maxSize      int64
+	noGitignore  bool
+	includeRegex []string
+	excludeRegex []string
+	grep         []string
+	excludeGrep  []string
+	maxTokens    int
+	highPriority []string
+	lowPriority  []string
+	splitTokens  int
+	splitBytes   int
+	format       string
+	images       string
+	extractDocs  bool
+	nbOutputs    bool
+	outline      bool
+	template     string
+	output       string
+	ref          string
+	noCache      bool
+	gitMeta      bool
+	gitHistory   int
+	githubAPIURL string
+	userAgent    string
+	timeout      time.Duration
+	crawlDepth   int
+	lang         string
+	goProxy      string
+	npmRegistry  string
+	pypiURL      string
+	goMod        string
+	withDeps     []string
 }
 
 var AppVersion = "dev-build"
@@ -71,10 +104,80 @@ var rootCmd = &cobra.Command{
 		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 		defer stop()
 
-		aicontext.Handler(ctx, urls, cmdFlags.includeGlobs, cmdFlags.excludeGlobs, cmdFlags.maxSize, cmdFlags.threads, false)
+		config := processorConfig(cmd)
+		aicontext.Handler(ctx, urls, config, cmdFlags.output, cmdFlags.threads, false)
 	},
 }
 
+// processorConfig validates the processing flags shared by the root and diff
+// commands and turns them into a ProcessorConfig.
+func processorConfig(cmd *cobra.Command) aicontext.ProcessorConfig {
+	if err := aicontext.ValidateFormat(cmdFlags.format); err != nil {
+		utils.PrintFatal("invalid --format", err)
+	}
+	if cmdFlags.output == aicontext.StdoutOutput && (cmdFlags.splitTokens > 0 || cmdFlags.splitBytes > 0) {
+		utils.PrintFatal("--split-tokens and --split-bytes cannot be used with --output -", nil)
+	}
+	if err := aicontext.ValidateImages(cmdFlags.images); err != nil {
+		utils.PrintFatal("invalid --images", err)
+	}
+	if cmdFlags.output == aicontext.StdoutOutput && cmdFlags.images == aicontext.ImagesDownload {
+		utils.PrintFatal("--images download cannot be used with --output -", nil)
+	}
+	config := aicontext.ProcessorConfig{
+		IncludeGlobs:    cmdFlags.includeGlobs,
+		ExcludeGlobs:    cmdFlags.excludeGlobs,
+		MaxSize:         cmdFlags.maxSize,
+		NoGitignore:     cmdFlags.noGitignore,
+		IncludeRegex:    compileRegexFlag("include-regex", cmdFlags.includeRegex),
+		ExcludeRegex:    compileRegexFlag("exclude-regex", cmdFlags.excludeRegex),
+		Grep:            compileRegexFlag("grep", cmdFlags.grep),
+		ExcludeGrep:     compileRegexFlag("exclude-grep", cmdFlags.excludeGrep),
+		MaxTokens:       cmdFlags.maxTokens,
+		HighPriority:    cmdFlags.highPriority,
+		LowPriority:     cmdFlags.lowPriority,
+		SplitTokens:     cmdFlags.splitTokens,
+		SplitBytes:      cmdFlags.splitBytes,
+		Format:          cmdFlags.format,
+		Template:        cmdFlags.template,
+		Ref:             cmdFlags.ref,
+		GitMeta:         cmdFlags.gitMeta,
+		GitHistory:      cmdFlags.gitHistory,
+		NoCache:         cmdFlags.noCache,
+		GitHubAPIURL:    cmdFlags.githubAPIURL,
+		UserAgent:       cmdFlags.userAgent,
+		WebTimeout:      cmdFlags.timeout,
+		CrawlDepth:      cmdFlags.crawlDepth,
+		Images:          cmdFlags.images,
+		ExtractDocs:     cmdFlags.extractDocs,
+		NotebookOutputs: cmdFlags.nbOutputs,
+		Outline:         cmdFlags.outline,
+		CaptionLanguage: cmdFlags.lang,
+		GoProxyURL:      cmdFlags.goProxy,
+		NPMRegistryURL:  cmdFlags.npmRegistry,
+		PyPIURL:         cmdFlags.pypiURL,
+		GoModFile:       cmdFlags.goMod,
+		WithDeps:        cmdFlags.withDeps,
+		FlagsSet:        make(map[string]bool),
+	}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		config.FlagsSet[f.Name] = true
+	})
+	return config
+}
+
+func compileRegexFlag(name string, patterns []string) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			utils.PrintFatal(fmt.Sprintf("invalid --%s pattern %q", name, pattern), err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -83,9 +186,13 @@ func Execute() {
 }
 
 func setupLogs() {
+	// Keep stdout clean for the context itself when it is written there.
+	if cmdFlags.output == aicontext.StdoutOutput {
+		utils.SetOutput(os.Stderr)
+	}
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	output := zerolog.ConsoleWriter{
-		Out:        os.Stdout,
+		Out:        utils.Output(),
 		TimeFormat: time.DateTime,
 		NoColor:    false,
 	}
@@ -111,8 +218,47 @@ func init() {
 	cobra.OnInitialize(setupLogs)
 
 	rootCmd.Flags().StringVarP(&cmdFlags.listFile, "file", "f", "", "File with list of URLs to process")
+	rootCmd.Flags().StringVar(&cmdFlags.ref, "ref", "", "Branch, tag or commit to clone for repository URLs that do not specify one")
+	rootCmd.Flags().BoolVar(&cmdFlags.noCache, "no-cache", false, "Clone repositories into a temporary directory instead of the checkout cache")
+	rootCmd.Flags().StringVar(&cmdFlags.githubAPIURL, "github-api-url", aicontext.DefaultGitHubAPIURL, "GitHub REST API base URL for pull request and issue URLs")
+	rootCmd.Flags().StringVar(&cmdFlags.userAgent, "user-agent", aicontext.DefaultUserAgent, "User agent for fetching web pages")
+	rootCmd.Flags().DurationVar(&cmdFlags.timeout, "timeout", aicontext.DefaultWebTimeout, "Timeout for each web request")
+	rootCmd.Flags().IntVar(&cmdFlags.crawlDepth, "crawl-depth", 0, "Levels of same-site links to follow from web pages")
+	rootCmd.Flags().StringVar(&cmdFlags.lang, "lang", aicontext.DefaultCaptionLanguage, "Language of YouTube transcripts")
+	rootCmd.Flags().StringVar(&cmdFlags.goProxy, "go-proxy", "", "Go module proxy for go: sources (default first proxy of $GOPROXY, else "+aicontext.DefaultGoProxy+")")
+	rootCmd.Flags().StringVar(&cmdFlags.npmRegistry, "npm-registry", aicontext.DefaultNPMRegistry, "npm registry for npm: sources")
+	rootCmd.Flags().StringVar(&cmdFlags.pypiURL, "pypi-url", aicontext.DefaultPyPIURL, "Python package index for pypi: sources")
+	rootCmd.Flags().StringSliceVar(&cmdFlags.withDeps, "with-deps", []string{}, "Add the sources of Go modules required by go.mod that match globs (e.g., 'github.com/spf13/*') from the module cache")
+	rootCmd.Flags().StringVar(&cmdFlags.goMod, "go-mod", "", "go.mod file resolving the version of go: sources that do not specify one")
 	rootCmd.Flags().IntVarP(&cmdFlags.threads, "threads", "t", 10, "Number of threads to use for processing")
-	rootCmd.Flags().StringSliceVarP(&cmdFlags.includeGlobs, "include", "i", []string{}, "Include files matching globs (e.g., '*.go,*.md')")
-	rootCmd.Flags().StringSliceVarP(&cmdFlags.excludeGlobs, "exclude", "e", []string{}, "Exclude files matching globs (e.g., 'tests,docs')")
-	rootCmd.Flags().Int64VarP(&cmdFlags.maxSize, "max-size", "s", 10485760, "Maximum file size in bytes to include (default 10MB)")
+	addProcessingFlags(rootCmd)
+}
+
+// addProcessingFlags registers the filtering and output flags shared by the
+// root and diff commands.
+func addProcessingFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&cmdFlags.output, "output", "o", "", "Output file, directory, '-' for stdout, or naming template like '{type}-{name}.md' (default context/)")
+	cmd.Flags().StringSliceVarP(&cmdFlags.includeGlobs, "include", "i", []string{}, "Include files matching globs (e.g., '*.go,*.md')")
+	cmd.Flags().StringSliceVarP(&cmdFlags.excludeGlobs, "exclude", "e", []string{}, "Exclude files matching globs (e.g., 'tests,docs')")
+	cmd.Flags().Int64VarP(&cmdFlags.maxSize, "max-size", "s", 10485760, "Maximum file size in bytes to include (default 10MB)")
+	cmd.Flags().BoolVar(&cmdFlags.noGitignore, "no-gitignore", false, "Do not apply .gitignore and .git/info/exclude rules")
+	cmd.Flags().StringArrayVar(&cmdFlags.includeRegex, "include-regex", []string{}, "Include only files whose relative path matches regex (repeatable)")
+	cmd.Flags().StringArrayVar(&cmdFlags.excludeRegex, "exclude-regex", []string{}, "Exclude files whose relative path matches regex (repeatable)")
+	cmd.Flags().StringArrayVar(&cmdFlags.grep, "grep", []string{}, "Include only files whose content matches regex (repeatable)")
+	cmd.Flags().StringArrayVar(&cmdFlags.excludeGrep, "exclude-grep", []string{}, "Exclude files whose content matches regex (repeatable)")
+	cmd.Flags().IntVar(&cmdFlags.maxTokens, "max-tokens", 0, "Drop or truncate files until the output fits in this many estimated tokens")
+	cmd.Flags().StringSliceVar(&cmdFlags.highPriority, "priority", []string{}, "Globs for files to keep longest under --max-tokens (added to README and entry points)")
+	cmd.Flags().StringSliceVar(&cmdFlags.lowPriority, "low-priority", []string{}, "Globs for files to drop first under --max-tokens (added to tests and fixtures)")
+	cmd.Flags().IntVar(&cmdFlags.splitTokens, "split-tokens", 0, "Split output into part files of at most this many estimated tokens")
+	cmd.Flags().IntVar(&cmdFlags.splitBytes, "split-bytes", 0, "Split output into part files of at most this many bytes")
+	cmd.MarkFlagsMutuallyExclusive("split-tokens", "split-bytes")
+	cmd.Flags().StringVar(&cmdFlags.format, "format", "markdown", "Output format ("+strings.Join(aicontext.OutputFormats, "|")+")")
+	cmd.Flags().BoolVar(&cmdFlags.gitMeta, "git-meta", false, "Annotate files with the last commit that changed them (clones keep full history)")
+	cmd.Flags().IntVar(&cmdFlags.gitHistory, "git-history", 0, "Add a Recent History section with this many latest commits")
+	cmd.Flags().StringVar(&cmdFlags.images, "images", aicontext.ImagesNone, "Images of web pages and directories ("+strings.Join(aicontext.ImageModes, "|")+")")
+	cmd.Flags().BoolVar(&cmdFlags.extractDocs, "extract-docs", false, "Convert PDF, DOCX and Jupyter notebook files to Markdown")
+	cmd.Flags().BoolVar(&cmdFlags.nbOutputs, "notebook-outputs", false, "Keep cell outputs of notebooks converted with --extract-docs")
+	cmd.Flags().BoolVar(&cmdFlags.outline, "outline", false, "Reduce Go files to package docs, types, and exported declarations and signatures without function bodies")
+	cmd.Flags().StringVar(&cmdFlags.template, "template", "", "Go text/template file to render output with (see 'ai-context template dump')")
+	cmd.MarkFlagsMutuallyExclusive("format", "template")
 }
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/tanq16/ai-context/internal/aicontext"
+	"github.com/tanq16/ai-context/utils"
+)
+
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Work with output templates.",
+}
+
+var templateDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Print the built-in markdown template as a starting point for --template.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		utils.PrintGeneric(aicontext.BuiltinTemplate())
+	},
+}
+
+func init() {
+	templateCmd.AddCommand(templateDumpCmd)
+	rootCmd.AddCommand(templateCmd)
+}
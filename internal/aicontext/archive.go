@@ -0,0 +1,326 @@
+package aicontext
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxArchiveBytes bounds the download of a remote archive.
+	maxArchiveBytes = 1 << 30
+	// maxExtractedBytes and maxArchiveEntries bound what an archive may
+	// expand to, against decompression bombs.
+	maxExtractedBytes = 1 << 30
+	maxArchiveEntries = 100000
+)
+
+// archiveSuffixes are the archive types accepted as sources.
+var archiveSuffixes = []string{".zip", ".tar.gz", ".tgz", ".tar"}
+
+var errArchiveTooLarge = errors.New("archive expands to more than the allowed size")
+
+// isArchive reports whether a local path or http(s) URL names an archive.
+func isArchive(source string) bool {
+	name := source
+	if webURLRegex.MatchString(source) {
+		parsed, err := url.Parse(source)
+		if err != nil {
+			return false
+		}
+		name = parsed.Path
+	}
+	name = strings.ToLower(name)
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessArchive extracts a local or downloaded .zip, .tar.gz or .tar into a
+// temporary directory and processes it like a local directory. An archive
+// holding a single top-level directory, as produced by git hosts, is
+// processed from inside that directory.
+func (p *Processor) ProcessArchive(source string) error {
+	file, cleanup, err := p.openArchive(source)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	dir, err := p.extractArchive(file)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	return p.ProcessDirectory(archiveRoot(dir))
+}
+
+// extractArchive extracts a zip, gzipped tar or tar file, told apart by
+// their content, into a temporary directory the caller removes.
+func (p *Processor) extractArchive(file *os.File) (string, error) {
+	header := make([]byte, 4)
+	if _, err := file.ReadAt(header, 0); err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read archive: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp("", "ai-context-archive-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	extractor := newArchiveExtractor(dir, p.config.MaxSize)
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		err = extractor.extractZip(file, info.Size())
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(io.NewSectionReader(file, 0, info.Size())); err == nil {
+			err = extractor.extractTar(gz)
+		}
+	default:
+		err = extractor.extractTar(io.NewSectionReader(file, 0, info.Size()))
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to extract archive: %w", err)
+	}
+	return dir, nil
+}
+
+// openArchive opens a local archive or downloads a remote one into a
+// temporary file.
+func (p *Processor) openArchive(source string) (*os.File, func(), error) {
+	if !webURLRegex.MatchString(source) {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		return file, func() { file.Close() }, nil
+	}
+	return p.downloadArchive(source)
+}
+
+// downloadArchive downloads an archive into a temporary file that cleanup
+// closes and removes.
+func (p *Processor) downloadArchive(source string) (*os.File, func(), error) {
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	userAgent := p.config.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	client := p.webClient()
+	if client.Transport == nil {
+		// Downloads may outlast --timeout, which then only bounds the wait
+		// for the response.
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.ResponseHeaderTimeout = client.Timeout
+		client.Transport, client.Timeout = transport, 0
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to download archive: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to download archive: %s", resp.Status)
+	}
+	file, err := os.CreateTemp("", "ai-context-download-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	cleanup := func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	written, err := io.Copy(file, io.LimitReader(resp.Body, maxArchiveBytes+1))
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to download archive: %w", err)
+	}
+	if written > maxArchiveBytes {
+		cleanup()
+		return nil, nil, fmt.Errorf("archive is larger than %d bytes", maxArchiveBytes)
+	}
+	log.Debug().Str("package", "aicontext").Str("url", source).Int64("bytes", written).Msg("downloaded archive")
+	return file, cleanup, nil
+}
+
+// archiveExtractor writes archive entries below dir, refusing entries that
+// would land outside it and stopping once maxBytes or maxEntries are
+// exceeded. Files over maxSize are skipped since they would not be
+// processed anyway.
+type archiveExtractor struct {
+	dir        string
+	maxSize    int64
+	maxBytes   int64
+	maxEntries int
+	written    int64
+	entries    int
+}
+
+func newArchiveExtractor(dir string, maxSize int64) *archiveExtractor {
+	return &archiveExtractor{dir: dir, maxSize: maxSize, maxBytes: maxExtractedBytes, maxEntries: maxArchiveEntries}
+}
+
+// target returns where an entry goes, or an error for absolute paths and
+// paths escaping the directory ("zip slip").
+func (e *archiveExtractor) target(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) || filepath.VolumeName(name) != "" || slices.Contains(strings.Split(name, "/"), "..") {
+		return "", fmt.Errorf("unsafe path in archive: %q", name)
+	}
+	target := filepath.Join(e.dir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(e.dir, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("unsafe path in archive: %q", name)
+	}
+	return target, nil
+}
+
+func (e *archiveExtractor) count() error {
+	e.entries++
+	if e.entries > e.maxEntries {
+		return fmt.Errorf("archive has more than %d entries", e.maxEntries)
+	}
+	return nil
+}
+
+// write copies one file, counting its actual rather than declared size
+// against the limits: zip headers may understate it.
+func (e *archiveExtractor) write(name string, size int64, r io.Reader) error {
+	if e.maxSize > 0 && size > e.maxSize {
+		return nil
+	}
+	target, err := e.target(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	remaining := e.maxBytes - e.written
+	limit := remaining
+	if e.maxSize > 0 && e.maxSize < limit {
+		limit = e.maxSize
+	}
+	written, err := io.Copy(file, io.LimitReader(r, limit+1))
+	e.written += written
+	if err != nil {
+		return err
+	}
+	if written > remaining {
+		return errArchiveTooLarge
+	}
+	if e.maxSize > 0 && written > e.maxSize {
+		log.Debug().Str("package", "aicontext").Str("entry", name).Msg("skipping archive entry larger than its header")
+		file.Close()
+		return os.Remove(target)
+	}
+	return nil
+}
+
+func (e *archiveExtractor) mkdir(name string) error {
+	target, err := e.target(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(target, 0755)
+}
+
+func (e *archiveExtractor) extractZip(r io.ReaderAt, size int64) error {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, entry := range archive.File {
+		if err := e.count(); err != nil {
+			return err
+		}
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			err = e.mkdir(entry.Name)
+		case mode.IsRegular():
+			err = e.extractZipFile(entry)
+		default:
+			// Symlinks could point outside the directory.
+			log.Debug().Str("package", "aicontext").Str("entry", entry.Name).Msg("skipping special archive entry")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *archiveExtractor) extractZipFile(entry *zip.File) error {
+	rc, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return e.write(entry.Name, int64(entry.UncompressedSize64), rc)
+}
+
+func (e *archiveExtractor) extractTar(r io.Reader) error {
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := e.count(); err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = e.mkdir(header.Name)
+		case tar.TypeReg:
+			err = e.write(header.Name, header.Size, reader)
+		default:
+			// Links could point outside the directory; pax headers
+			// like the commit comment of git archives are not files.
+			log.Debug().Str("package", "aicontext").Str("entry", header.Name).Msg("skipping special archive entry")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// archiveRoot descends into the only entry of dir while it is a directory.
+func archiveRoot(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return dir
+	}
+	return filepath.Join(dir, entries[0].Name())
+}
@@ -0,0 +1,188 @@
+package aicontext
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type testEntry struct {
+	name string
+	body string
+	// mode and typeflag select links; declared, when set, is the size the
+	// zip header claims instead of the real one.
+	mode     fs.FileMode
+	typeflag byte
+	link     string
+	declared uint64
+}
+
+func zipBytes(t *testing.T, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Store}
+		if entry.mode != 0 {
+			header.SetMode(entry.mode)
+		}
+		var fw io.Writer
+		var err error
+		if entry.declared > 0 {
+			header.CRC32 = crc32.ChecksumIEEE([]byte(entry.body))
+			header.CompressedSize64 = uint64(len(entry.body))
+			header.UncompressedSize64 = entry.declared
+			fw, err = w.CreateRaw(header)
+		} else {
+			fw, err = w.CreateHeader(header)
+		}
+		if err == nil {
+			_, err = fw.Write([]byte(entry.body))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarBytes(t *testing.T, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Typeflag: entry.typeflag, Linkname: entry.link}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.body))
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extractedFiles lists the files below dir as slash-separated paths.
+func extractedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestArchiveExtractor(t *testing.T) {
+	tests := []struct {
+		name       string
+		zip        bool
+		entries    []testEntry
+		maxSize    int64
+		maxBytes   int64
+		maxEntries int
+		wantErr    string
+		wantFiles  []string
+	}{
+		{name: "plain zip", zip: true, entries: []testEntry{{name: "a/b.txt", body: "b"}, {name: "c.txt", body: "c"}}, wantFiles: []string{"a/b.txt", "c.txt"}},
+		{name: "plain tar", entries: []testEntry{{name: "a/", typeflag: tar.TypeDir}, {name: "a/b.txt", body: "b"}}, wantFiles: []string{"a/b.txt"}},
+		{name: "zip parent path", zip: true, entries: []testEntry{{name: "../evil.txt", body: "x"}}, wantErr: "unsafe path"},
+		{name: "zip nested parent path", zip: true, entries: []testEntry{{name: "a/../../evil.txt", body: "x"}}, wantErr: "unsafe path"},
+		{name: "zip backslash parent path", zip: true, entries: []testEntry{{name: `..\evil.txt`, body: "x"}}, wantErr: "unsafe path"},
+		{name: "tar parent path", entries: []testEntry{{name: "../../evil.txt", body: "x"}}, wantErr: "unsafe path"},
+		{name: "zip absolute path", zip: true, entries: []testEntry{{name: "/tmp/evil.txt", body: "x"}}, wantErr: "unsafe path"},
+		{name: "tar absolute path", entries: []testEntry{{name: "/etc/evil", body: "x"}}, wantErr: "unsafe path"},
+		{name: "zip symlink skipped", zip: true, entries: []testEntry{{name: "link", body: "/etc/passwd", mode: fs.ModeSymlink | 0o777}, {name: "ok.txt", body: "ok"}}, wantFiles: []string{"ok.txt"}},
+		{name: "tar symlink skipped", entries: []testEntry{{name: "link", typeflag: tar.TypeSymlink, link: "../../etc/passwd"}, {name: "ok.txt", body: "ok"}}, wantFiles: []string{"ok.txt"}},
+		{name: "tar hardlink skipped", entries: []testEntry{{name: "hard", typeflag: tar.TypeLink, link: "/etc/passwd"}, {name: "ok.txt", body: "ok"}}, wantFiles: []string{"ok.txt"}},
+		{name: "over max size skipped", entries: []testEntry{{name: "big.txt", body: strings.Repeat("x", 100)}, {name: "ok.txt", body: "ok"}}, maxSize: 10, wantFiles: []string{"ok.txt"}},
+		{name: "zip lying header", zip: true, entries: []testEntry{{name: "big.txt", body: strings.Repeat("x", 1000), declared: 5}}, maxSize: 10, wantErr: "zip"},
+		{name: "total size limit", entries: []testEntry{{name: "a.txt", body: strings.Repeat("a", 8)}, {name: "b.txt", body: strings.Repeat("b", 8)}}, maxBytes: 10, wantErr: errArchiveTooLarge.Error()},
+		{name: "entry limit", zip: true, entries: []testEntry{{name: "a", body: "a"}, {name: "b", body: "b"}, {name: "c", body: "c"}}, maxEntries: 2, wantErr: "more than 2 entries"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			extractor := newArchiveExtractor(dir, tt.maxSize)
+			if tt.maxBytes > 0 {
+				extractor.maxBytes = tt.maxBytes
+			}
+			if tt.maxEntries > 0 {
+				extractor.maxEntries = tt.maxEntries
+			}
+			var err error
+			if tt.zip {
+				data := zipBytes(t, tt.entries...)
+				err = extractor.extractZip(bytes.NewReader(data), int64(len(data)))
+			} else {
+				err = extractor.extractTar(bytes.NewReader(tarBytes(t, tt.entries...)))
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			files := extractedFiles(t, dir)
+			if tt.wantErr == "" && !slices.Equal(files, tt.wantFiles) {
+				t.Errorf("extracted %v, want %v", files, tt.wantFiles)
+			}
+			for _, file := range files {
+				info, err := os.Stat(filepath.Join(dir, file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.maxSize > 0 && info.Size() > tt.maxSize {
+					t.Errorf("%s has %d bytes, over the max size", file, info.Size())
+				}
+			}
+			if entries, _ := os.ReadDir(filepath.Dir(dir)); len(entries) != 1 {
+				t.Errorf("extraction wrote next to its directory: %v", entries)
+			}
+		})
+	}
+}
+
+func TestArchiveExtractorUnderstatedSize(t *testing.T) {
+	dir := t.TempDir()
+	extractor := newArchiveExtractor(dir, 10)
+	// The declared size passes the check; the content does not.
+	if err := extractor.write("big.txt", 5, strings.NewReader(strings.Repeat("x", 1000))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("oversized file was kept: %v", err)
+	}
+	if extractor.written > 11 {
+		t.Errorf("copied %d bytes, want at most max size + 1", extractor.written)
+	}
+}
@@ -0,0 +1,244 @@
+package aicontext
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/rs/zerolog/log"
+	sshagent "github.com/xanzy/ssh-agent"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultSSHKeys are tried after the keys held by ssh-agent.
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// withAuth runs op against the remote, first with the configured
+// credentials and, for HTTPS remotes that turn out to need them, once more
+// with credentials from `git credential fill`. Errors are rewritten to tell
+// authentication problems apart from missing repositories.
+func (s *gitSource) withAuth(op func(auth transport.AuthMethod) error) error {
+	err := op(s.auth())
+	if isAuthError(err) && !s.isSSH() {
+		if helperAuth := credentialHelperAuth(s.cloneURL); helperAuth != nil {
+			log.Debug().Str("package", "aicontext").Str("url", s.cloneURL).Msg("retrying with git credential helper")
+			if err = op(helperAuth); err == nil {
+				s.authMethod = helperAuth
+			}
+		}
+	}
+	return s.describeError(err)
+}
+
+// auth picks credentials for the remote. HTTPS remotes use a token from the
+// environment, then a ~/.netrc entry, and go anonymous otherwise. SSH
+// remotes offer the keys in ssh-agent and the default keys in ~/.ssh; the
+// agent connection they sign through stays open until close. Without any
+// key the remote is still tried, so that its error is reported.
+func (s *gitSource) auth() transport.AuthMethod {
+	if s.authMethod != nil {
+		return s.authMethod
+	}
+	if s.isSSH() {
+		signers, closeAgent := sshSigners()
+		if len(signers) == 0 {
+			log.Debug().Str("package", "aicontext").Str("host", s.host).Msg("no keys in ssh-agent or ~/.ssh")
+		}
+		s.closeAuth = closeAgent
+		s.authMethod = &gitssh.PublicKeysCallback{
+			User:     s.sshUser(),
+			Callback: func() ([]ssh.Signer, error) { return signers, nil },
+		}
+		return s.authMethod
+	}
+	if token := s.token(); token != "" {
+		s.authMethod = &http.BasicAuth{Username: s.provider.tokenUser, Password: token}
+	} else if login, password, ok := netrcCredentials(s.host); ok {
+		s.authMethod = &http.BasicAuth{Username: login, Password: password}
+	}
+	return s.authMethod
+}
+
+// close releases the ssh-agent connection opened by auth.
+func (s *gitSource) close() {
+	if s.closeAuth != nil {
+		s.closeAuth()
+		s.closeAuth = nil
+	}
+}
+
+func (s *gitSource) sshUser() string {
+	if match := matchSCPLike(s.cloneURL); match != nil {
+		if user := strings.TrimSuffix(match[1], "@"); user != "" {
+			return user
+		}
+	} else if parsed, err := url.Parse(s.cloneURL); err == nil && parsed.User != nil {
+		return parsed.User.Username()
+	}
+	return "git"
+}
+
+func isAuthError(err error) bool {
+	return errors.Is(err, transport.ErrAuthenticationRequired) || errors.Is(err, transport.ErrAuthorizationFailed)
+}
+
+func (s *gitSource) describeError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, transport.ErrAuthenticationRequired):
+		return fmt.Errorf("authentication required for %s: set %s, add a ~/.netrc entry for %s, or configure a git credential helper: %w",
+			s.cloneURL, strings.Join(s.tokenEnvs(), " or "), s.host, err)
+	case errors.Is(err, transport.ErrAuthorizationFailed):
+		return fmt.Errorf("credentials rejected by %s: check that the token or password is valid and can read the repository: %w", s.host, err)
+	case errors.Is(err, transport.ErrRepositoryNotFound):
+		return fmt.Errorf("repository %s not found (some hosts also report private repositories this way when credentials are missing): %w", s.cloneURL, err)
+	case s.isSSH() && strings.Contains(err.Error(), "unable to authenticate"):
+		return fmt.Errorf("ssh authentication failed for %s@%s: no key in ssh-agent or ~/.ssh was accepted: %w", s.sshUser(), s.host, err)
+	}
+	return err
+}
+
+// sshSigners collects the keys held by ssh-agent followed by unencrypted
+// (or AI_CONTEXT_SSH_PASSPHRASE-protected) default keys from ~/.ssh. Agent
+// keys sign through the agent connection, which the returned function
+// closes.
+func sshSigners() ([]ssh.Signer, func()) {
+	var signers []ssh.Signer
+	closeAgent := func() {}
+	if sshagent.Available() {
+		if agent, conn, err := sshagent.New(); err == nil {
+			if agentSigners, err := agent.Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+			if conn != nil {
+				closeAgent = func() { conn.Close() }
+			}
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return signers, closeAgent
+	}
+	passphrase := os.Getenv("AI_CONTEXT_SSH_PASSPHRASE")
+	for _, name := range defaultSSHKeys {
+		pemBytes, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(pemBytes)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) && passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+		}
+		if err != nil {
+			log.Debug().Str("package", "aicontext").Str("key", name).Err(err).Msg("skipping ssh key")
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	return signers, closeAgent
+}
+
+// netrcCredentials looks host up in $NETRC or ~/.netrc, falling back to a
+// default entry.
+func netrcCredentials(host string) (string, string, bool) {
+	path := os.Getenv("NETRC")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", false
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", false
+	}
+	var login, password, defLogin, defPassword string
+	var machine string
+	inDefault, found := false, false
+	fields := strings.Fields(string(content))
+	for i := 0; i < len(fields); i++ {
+		next := func() string {
+			if i+1 < len(fields) {
+				i++
+				return fields[i]
+			}
+			return ""
+		}
+		switch fields[i] {
+		case "machine":
+			if found {
+				return login, password, true
+			}
+			machine, inDefault = next(), false
+			found = machine == host
+		case "default":
+			if found {
+				return login, password, true
+			}
+			machine, inDefault = "", true
+		case "login":
+			value := next()
+			if found {
+				login = value
+			} else if inDefault {
+				defLogin = value
+			}
+		case "password":
+			value := next()
+			if found {
+				password = value
+			} else if inDefault {
+				defPassword = value
+			}
+		}
+	}
+	if found {
+		return login, password, true
+	}
+	if defPassword != "" {
+		return defLogin, defPassword, true
+	}
+	return "", "", false
+}
+
+// credentialHelperAuth asks the user's configured git credential helpers
+// for the remote. Prompts are disabled so that it never blocks.
+func credentialHelperAuth(cloneURL string) transport.AuthMethod {
+	parsed, err := url.Parse(cloneURL)
+	if err != nil {
+		return nil
+	}
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GCM_INTERACTIVE=never")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=%s\nhost=%s\npath=%s\n\n", parsed.Scheme, parsed.Host, strings.TrimPrefix(parsed.Path, "/")))
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	var username, password string
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "username":
+			username = value
+		case "password":
+			password = value
+		}
+	}
+	if password == "" {
+		return nil
+	}
+	return &http.BasicAuth{Username: username, Password: password}
+}
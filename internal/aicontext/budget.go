@@ -0,0 +1,196 @@
+package aicontext
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Budget tiers decide which files are dropped first when --max-tokens is set.
+// Within a tier the largest file goes first.
+const (
+	tierHigh = iota
+	tierNormal
+	tierLow
+)
+
+// defaultHighPriority keeps READMEs and common entry points for as long as
+// possible.
+var defaultHighPriority = []string{
+	"README*",
+	"readme*",
+	"main.go",
+	"main.py",
+	"__main__.py",
+	"app.py",
+	"main.rs",
+	"lib.rs",
+	"index.js",
+	"index.ts",
+	"cmd/**",
+}
+
+// defaultLowPriority marks tests and fixtures as the first files to drop.
+var defaultLowPriority = []string{
+	"*_test.go",
+	"test_*.py",
+	"*_test.py",
+	"*.test.*",
+	"*.spec.*",
+	"**/test/**",
+	"**/tests/**",
+	"**/testdata/**",
+	"**/fixtures/**",
+	"**/__tests__/**",
+}
+
+// OmittedEntry records a file that was dropped or truncated to fit the budget.
+type OmittedEntry struct {
+	Path   string `json:"path"`
+	Tokens int    `json:"tokens"`
+	Reason string `json:"reason"`
+}
+
+// minTruncatedTokens is the smallest useful remainder of a truncated file;
+// below it the file is dropped entirely.
+const minTruncatedTokens = 200
+
+func countTokens(text string) int {
+	return estimateTokens(text, utf8.RuneCountInString(text))
+}
+
+func (p *Processor) budgetTier(path string) int {
+	matches := func(pattern string) bool { return matchGlob(pattern, path) }
+	if slices.ContainsFunc(p.config.LowPriority, matches) || slices.ContainsFunc(defaultLowPriority, matches) {
+		return tierLow
+	}
+	if slices.ContainsFunc(p.config.HighPriority, matches) || slices.ContainsFunc(defaultHighPriority, matches) {
+		return tierHigh
+	}
+	return tierNormal
+}
+
+// fileBlockTokens estimates what a file adds to the rendered output in the
+// selected format, as the difference to an output without files.
+func (p *Processor) fileBlockTokens(file FileEntry) int {
+	var empty, single strings.Builder
+	renderer := p.renderer()
+	if renderer.Render(&empty, &Output{}) != nil || renderer.Render(&single, &Output{Files: []FileEntry{file}}) != nil {
+		return file.Tokens
+	}
+	return countTokens(single.String()) - countTokens(empty.String())
+}
+
+func omittedLineTokens(entry OmittedEntry) int {
+	return countTokens(fmt.Sprintf("- %s (~%d tokens): %s\n", entry.Path, entry.Tokens, entry.Reason))
+}
+
+// applyTokenBudget drops or truncates files, lowest tier and largest first,
+// until the rendered output is estimated to fit in MaxTokens.
+func (p *Processor) applyTokenBudget(output *Output) error {
+	if p.config.MaxTokens <= 0 {
+		return nil
+	}
+	var rendered strings.Builder
+	if err := p.renderer().Render(&rendered, output); err != nil {
+		return err
+	}
+	total := countTokens(rendered.String())
+	if total <= p.config.MaxTokens {
+		return nil
+	}
+
+	type candidate struct {
+		index  int
+		tier   int
+		tokens int
+	}
+	candidates := make([]candidate, len(output.Files))
+	for i, file := range output.Files {
+		tier := p.budgetTier(file.Path)
+		if file.Dependency != "" {
+			tier = tierLow
+		}
+		candidates[i] = candidate{index: i, tier: tier, tokens: p.fileBlockTokens(file)}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		if a.tier != b.tier {
+			return b.tier - a.tier
+		}
+		return b.tokens - a.tokens
+	})
+
+	// Header for the omitted section in the template.
+	total += countTokens("## Omitted Files\n\n\n")
+	dropped := make(map[int]bool)
+	for _, c := range candidates {
+		if total <= p.config.MaxTokens {
+			break
+		}
+		file := &output.Files[c.index]
+		excess := total - p.config.MaxTokens
+		keep := c.tokens - excess - minTruncatedTokens/4
+		if keep >= minTruncatedTokens {
+			truncated, removedLines := truncateToTokens(file.Content, keep)
+			entry := OmittedEntry{
+				Path:   file.Path,
+				Tokens: c.tokens - countTokens(truncated),
+				Reason: fmt.Sprintf("truncated, last %d lines removed to fit token budget", removedLines),
+			}
+			file.Content = truncated
+			file.Size = int64(len(truncated))
+			file.Tokens = countTokens(truncated)
+			file.Truncated = true
+			output.Omitted = append(output.Omitted, entry)
+			total += p.fileBlockTokens(*file) - c.tokens + omittedLineTokens(entry)
+			continue
+		}
+		entry := OmittedEntry{
+			Path:   file.Path,
+			Tokens: c.tokens,
+			Reason: fmt.Sprintf("dropped to fit token budget (%s priority)", tierName(c.tier)),
+		}
+		dropped[c.index] = true
+		output.Omitted = append(output.Omitted, entry)
+		total += omittedLineTokens(entry) - c.tokens
+	}
+
+	kept := make([]FileEntry, 0, len(output.Files)-len(dropped))
+	for i, file := range output.Files {
+		if !dropped[i] {
+			kept = append(kept, file)
+		}
+	}
+	output.Files = kept
+	output.updateTotals()
+	return nil
+}
+
+const truncationMarker = "\n... [truncated]\n"
+
+// truncateToTokens keeps whole lines from the start of content until the
+// token estimate, including the truncation marker, reaches limit, and
+// reports how many lines were removed.
+func truncateToTokens(content string, limit int) (string, int) {
+	lines := strings.SplitAfter(content, "\n")
+	tokens := float64(countTokens(truncationMarker))
+	for i, line := range lines {
+		tokens += tokenEstimate(line, utf8.RuneCountInString(line))
+		if tokens > float64(limit) {
+			return strings.Join(lines[:i], "") + truncationMarker, len(lines) - i
+		}
+	}
+	return content, 0
+}
+
+func tierName(tier int) string {
+	switch tier {
+	case tierHigh:
+		return "high"
+	case tierLow:
+		return "low"
+	default:
+		return "normal"
+	}
+}
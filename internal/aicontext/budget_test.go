@@ -0,0 +1,119 @@
+package aicontext
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// budgetFile is a file of the given number of lines.
+func budgetFile(path string, lines int) FileEntry {
+	var content strings.Builder
+	for i := range lines {
+		fmt.Fprintf(&content, "// %s line %d with some words\n", path, i)
+	}
+	return FileEntry{Path: path, Content: content.String(), Size: int64(content.Len()), Tokens: countTokens(content.String())}
+}
+
+func renderedTokens(t *testing.T, p *Processor, output *Output) int {
+	t.Helper()
+	var rendered strings.Builder
+	if err := p.renderer().Render(&rendered, output); err != nil {
+		t.Fatal(err)
+	}
+	return countTokens(rendered.String())
+}
+
+func TestApplyTokenBudget(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []FileEntry
+		// fit lists the files the budget is sized for, plus slack tokens.
+		fit         []string
+		slack       int
+		wantKept    []string
+		wantOmitted []string
+		wantTrunc   string
+	}{
+		{
+			name: "tests dropped first",
+			files: []FileEntry{
+				budgetFile("main.go", 10), budgetFile("pkg/a.go", 40), budgetFile("pkg/a_test.go", 60),
+				budgetFile("pkg/testdata/case.json", 30), budgetFile("web/app.spec.ts", 20),
+			},
+			fit:         []string{"main.go", "pkg/a.go"},
+			slack:       150,
+			wantKept:    []string{"main.go", "pkg/a.go"},
+			wantOmitted: []string{"pkg/a_test.go", "pkg/testdata/case.json", "web/app.spec.ts"},
+		},
+		{
+			name: "readme and entry points kept",
+			files: []FileEntry{
+				budgetFile("README.md", 50), budgetFile("main.go", 30), budgetFile("cmd/root.go", 30),
+				budgetFile("pkg/big.go", 60), budgetFile("pkg/small.go", 10),
+			},
+			fit:         []string{"README.md", "main.go", "cmd/root.go"},
+			slack:       60,
+			wantKept:    []string{"README.md", "cmd/root.go", "main.go"},
+			wantOmitted: []string{"pkg/big.go", "pkg/small.go"},
+		},
+		{
+			name: "largest truncated first",
+			files: []FileEntry{
+				budgetFile("a.go", 20), budgetFile("b.go", 200), budgetFile("c.go", 60),
+			},
+			fit:         []string{"a.go", "c.go"},
+			slack:       400,
+			wantKept:    []string{"a.go", "b.go", "c.go"},
+			wantOmitted: []string{"b.go"},
+			wantTrunc:   "b.go",
+		},
+		{
+			name: "largest dropped first",
+			files: []FileEntry{
+				budgetFile("a.go", 20), budgetFile("b.go", 60), budgetFile("c.go", 40),
+			},
+			fit:         []string{"a.go", "c.go"},
+			slack:       40,
+			wantKept:    []string{"a.go", "c.go"},
+			wantOmitted: []string{"b.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProcessor(ProcessorConfig{})
+			var fit []FileEntry
+			for _, file := range tt.files {
+				if slices.Contains(tt.fit, file.Path) {
+					fit = append(fit, file)
+				}
+			}
+			p.config.MaxTokens = renderedTokens(t, p, &Output{Files: fit}) + tt.slack
+			output := &Output{Files: slices.Clone(tt.files)}
+			output.updateTotals()
+			if err := p.applyTokenBudget(output); err != nil {
+				t.Fatal(err)
+			}
+
+			var kept, omitted []string
+			for _, file := range output.Files {
+				kept = append(kept, file.Path)
+				if file.Truncated != (file.Path == tt.wantTrunc) {
+					t.Errorf("%s truncated = %v", file.Path, file.Truncated)
+				}
+			}
+			for _, entry := range output.Omitted {
+				omitted = append(omitted, entry.Path)
+			}
+			slices.Sort(kept)
+			slices.Sort(omitted)
+			if !slices.Equal(kept, tt.wantKept) || !slices.Equal(omitted, tt.wantOmitted) {
+				t.Errorf("kept %v and omitted %v, want %v and %v", kept, omitted, tt.wantKept, tt.wantOmitted)
+			}
+			if total := renderedTokens(t, p, output); total > p.config.MaxTokens {
+				t.Errorf("rendered %d tokens, over the budget of %d", total, p.config.MaxTokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package aicontext
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/rs/zerolog/log"
+)
+
+// Cached clones live in <user cache dir>/ai-context/repos/<host>/<repo>/@<ref>,
+// one checkout per ref, where <ref> is "default" for the remote's default
+// branch. Branch checkouts are fetched and reset on reuse; tag and commit
+// checkouts are reused as they are.
+const defaultRefKey = "default"
+
+// fullHistorySuffix marks checkouts cloned with their whole history, which
+// are kept apart from the shallow ones.
+const fullHistorySuffix = "+history"
+
+// cacheLocks serializes workers that use the same cached checkout. The
+// locks only hold within one process; separate ai-context processes that
+// update the same checkout at the same time may conflict.
+var cacheLocks sync.Map
+
+// CacheEntry is one cached checkout.
+type CacheEntry struct {
+	Repo string
+	Ref  string
+	// FullHistory is set for checkouts cloned for --git-meta or
+	// --git-history.
+	FullHistory bool
+	Path        string
+	Size        int64
+	HumanSize   string
+	LastUsed    time.Time
+}
+
+// CacheDir returns the directory holding cached clones.
+func CacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find cache directory: %w", err)
+	}
+	return filepath.Join(dir, "ai-context", "repos"), nil
+}
+
+func (s *gitSource) cacheDir() (string, error) {
+	root, err := CacheDir()
+	if err != nil {
+		return "", err
+	}
+	ref := s.ref
+	if ref == "" {
+		ref = defaultRefKey
+	}
+	name := "@" + url.PathEscape(ref)
+	if s.fullHistory {
+		name += fullHistorySuffix
+	}
+	// The host and path come from the URL and must not lead out of root.
+	segments := append([]string{s.host}, strings.Split(s.repoPath, "/")...)
+	if slices.ContainsFunc(segments, func(segment string) bool {
+		return segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, `/\`)
+	}) {
+		return "", fmt.Errorf("cannot cache repository %s/%s: invalid path", s.host, s.repoPath)
+	}
+	return filepath.Join(root, filepath.Join(segments...), name), nil
+}
+
+// checkout makes the source available on disk and returns its directory and
+// a function to call once processing is done. Without the cache, the clone
+// goes to a temporary directory that the function removes; with it, the
+// function releases the checkout's lock.
+func (s *gitSource) checkout(useCache bool) (string, func(), error) {
+	if !useCache {
+		tempDir, err := os.MkdirTemp("", "aicontext-clone-")
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		cleanup := func() { os.RemoveAll(tempDir) }
+		if err := s.cloneInto(tempDir); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+		return tempDir, cleanup, nil
+	}
+	dir, err := s.cacheDir()
+	if err != nil {
+		return "", nil, err
+	}
+	lock, _ := cacheLocks.LoadOrStore(dir, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	if err := s.refreshCache(dir); err != nil {
+		mu.Unlock()
+		return "", nil, err
+	}
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+	return dir, mu.Unlock, nil
+}
+
+// staleCheckoutError is a failed fetch that leaves a cached checkout usable
+// as it is, e.g. while offline or with expired credentials.
+type staleCheckoutError struct {
+	err error
+}
+
+func (e *staleCheckoutError) Error() string { return e.err.Error() }
+
+func (e *staleCheckoutError) Unwrap() error { return e.err }
+
+// refreshCache brings the cached checkout in dir up to date. It is cloned
+// again only when it is missing or broken or its branch is gone; when the
+// remote cannot be reached, the checkout is used as it is.
+func (s *gitSource) refreshCache(dir string) error {
+	if repo, err := git.PlainOpen(dir); err == nil {
+		err = s.withAuth(func(auth transport.AuthMethod) error { return updateCheckout(repo, auth, s.depth()) })
+		var stale *staleCheckoutError
+		switch {
+		case err == nil:
+			log.Debug().Str("package", "aicontext").Str("dir", dir).Msg("using cached clone")
+			return nil
+		case errors.As(err, &stale):
+			log.Warn().Str("package", "aicontext").Str("dir", dir).Err(err).Msg("failed to update cached clone, using it as it is")
+			return nil
+		}
+		log.Debug().Str("package", "aicontext").Str("dir", dir).Err(err).Msg("cached clone cannot be updated, cloning again")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err := s.cloneInto(dir); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func (s *gitSource) cloneInto(dir string) error {
+	err := s.withAuth(func(auth transport.AuthMethod) error {
+		if err := resetDir(dir); err != nil {
+			return err
+		}
+		return cloneSource(dir, s, auth)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clone repository: %w", err)
+	}
+	return nil
+}
+
+// updateCheckout fetches the branch a cached checkout is on and resets the
+// worktree to it. Detached checkouts (tags and commits) are left alone.
+func updateCheckout(repo *git.Repository, auth transport.AuthMethod, depth int) error {
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	if !head.Name().IsBranch() {
+		return nil
+	}
+	// Pruning drops the remote-tracking branch when the branch is gone, so
+	// the lookup below fails and the checkout is cloned again.
+	err = repo.Fetch(&git.FetchOptions{RemoteName: "origin", Auth: auth, Depth: depth, Force: true, Prune: true})
+	switch {
+	case errors.Is(err, git.NoMatchingRefSpecError{}):
+		// The branch is gone from the remote.
+		return err
+	case err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate):
+		return &staleCheckoutError{err}
+	}
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", head.Name().Short()), true)
+	if err != nil {
+		return err
+	}
+	if remoteRef.Hash() == head.Hash() {
+		return nil
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return worktree.Reset(&git.ResetOptions{Commit: remoteRef.Hash(), Mode: git.HardReset})
+}
+
+// CacheEntries lists the cached checkouts, most recently used first.
+func CacheEntries() ([]CacheEntry, error) {
+	root, err := CacheDir()
+	if err != nil {
+		return nil, err
+	}
+	var entries []CacheEntry
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() || !strings.HasPrefix(d.Name(), "@") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		repo, _ := filepath.Rel(root, filepath.Dir(path))
+		name, fullHistory := strings.CutSuffix(strings.TrimPrefix(d.Name(), "@"), fullHistorySuffix)
+		ref, _ := url.PathUnescape(name)
+		size := dirSize(path)
+		entries = append(entries, CacheEntry{
+			Repo:        filepath.ToSlash(repo),
+			Ref:         ref,
+			FullHistory: fullHistory,
+			Path:        path,
+			Size:        size,
+			HumanSize:   humanizeBytes(size),
+			LastUsed:    info.ModTime(),
+		})
+		return filepath.SkipDir
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.After(entries[j].LastUsed) })
+	return entries, err
+}
+
+// PruneCache removes checkouts not used within maxAge and returns them.
+func PruneCache(maxAge time.Duration) ([]CacheEntry, error) {
+	entries, err := CacheEntries()
+	if err != nil {
+		return nil, err
+	}
+	var pruned []CacheEntry
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		if entry.LastUsed.After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(entry.Path); err != nil {
+			return pruned, fmt.Errorf("failed to remove %s: %w", entry.Path, err)
+		}
+		pruned = append(pruned, entry)
+	}
+	root, _ := CacheDir()
+	removeEmptyDirs(root)
+	return pruned, nil
+}
+
+// ClearCache removes every cached checkout.
+func ClearCache() error {
+	root, err := CacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(root); err != nil {
+		return fmt.Errorf("failed to clear cache: %w", err)
+	}
+	return nil
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// removeEmptyDirs deletes the host and repository directories left empty
+// after pruning.
+func removeEmptyDirs(dir string) bool {
+	children, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	empty := true
+	for _, child := range children {
+		if !child.IsDir() || strings.HasPrefix(child.Name(), "@") || !removeEmptyDirs(filepath.Join(dir, child.Name())) {
+			empty = false
+		}
+	}
+	if empty {
+		os.Remove(dir)
+	}
+	return empty
+}
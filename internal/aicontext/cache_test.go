@@ -0,0 +1,196 @@
+package aicontext
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// setCacheHome points the user cache directory at a temporary directory.
+func setCacheHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+	root, err := CacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestGitSourceCacheDir(t *testing.T) {
+	root := setCacheHome(t)
+	tests := []struct {
+		name   string
+		source *gitSource
+		want   string
+	}{
+		{"default branch", &gitSource{host: "github.com", repoPath: "o/r"}, "github.com/o/r/@default"},
+		{"ref with slash", &gitSource{host: "github.com", repoPath: "o/r", ref: "feature/x"}, "github.com/o/r/@feature%2Fx"},
+		{"full history", &gitSource{host: "github.com", repoPath: "o/r", ref: "v1.0", fullHistory: true}, "github.com/o/r/@v1.0+history"},
+		{"nested group", &gitSource{host: "gitlab.com", repoPath: "g/sub/p"}, "gitlab.com/g/sub/p/@default"},
+		{"parent segment", &gitSource{host: "example.com", repoPath: "a/../../../etc"}, ""},
+		{"empty segment", &gitSource{host: "example.com", repoPath: "a//b"}, ""},
+		{"dot segment", &gitSource{host: "example.com", repoPath: "./b"}, ""},
+		{"backslash", &gitSource{host: "example.com", repoPath: `a\..\..\b`}, ""},
+		{"empty host", &gitSource{host: "", repoPath: "o/r"}, ""},
+		{"parent host", &gitSource{host: "..", repoPath: "o/r"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := tt.source.cacheDir()
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("got %s, want an error", dir)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(root, filepath.FromSlash(tt.want)); dir != want {
+				t.Errorf("got %s, want %s", dir, want)
+			}
+		})
+	}
+
+	source, err := parseGitURL("https://example.com/a/../../../etc.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, err := source.cacheDir(); err == nil {
+		t.Errorf("crafted url cached in %s", dir)
+	}
+}
+
+func TestCacheEntriesAndPrune(t *testing.T) {
+	root := setCacheHome(t)
+	now := time.Now()
+	checkouts := []struct {
+		path string
+		age  time.Duration
+	}{
+		{"github.com/o/r/@default", time.Hour},
+		{"github.com/o/r/@release%2F1.0+history", 48 * time.Hour},
+		{"gitlab.com/g/p/@main", 72 * time.Hour},
+	}
+	for _, checkout := range checkouts {
+		dir := filepath.Join(root, filepath.FromSlash(checkout.path))
+		if err := os.MkdirAll(filepath.Join(dir, "src"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		used := now.Add(-checkout.age)
+		if err := os.Chtimes(dir, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := CacheEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3: %+v", len(entries), entries)
+	}
+	if entries[0].Repo != "github.com/o/r" || entries[0].Ref != "default" || entries[0].FullHistory || entries[0].Size != int64(len("package main\n")) {
+		t.Errorf("unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Ref != "release/1.0" || !entries[1].FullHistory {
+		t.Errorf("unexpected second entry: %+v", entries[1])
+	}
+	if entries[2].Repo != "gitlab.com/g/p" {
+		t.Errorf("entries not sorted by last use: %+v", entries)
+	}
+
+	pruned, err := PruneCache(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 2 {
+		t.Errorf("pruned %+v, want the two checkouts older than a day", pruned)
+	}
+	if _, err := os.Stat(filepath.Join(root, "github.com", "o", "r", "@default")); err != nil {
+		t.Errorf("recent checkout was pruned: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "gitlab.com")); !os.IsNotExist(err) {
+		t.Errorf("empty host directory was kept: %v", err)
+	}
+
+	if err := ClearCache(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := CacheEntries(); err != nil || len(entries) != 0 {
+		t.Errorf("cache not cleared: %+v, %v", entries, err)
+	}
+}
+
+func TestRefreshCache(t *testing.T) {
+	remote := newTestRepo(t)
+	remote.commit("Initial", "main.go", "package main\n")
+	source := &gitSource{cloneURL: "file://" + filepath.ToSlash(remote.dir), host: "local", repoPath: "r", provider: genericProvider}
+	dir := filepath.Join(t.TempDir(), "@default")
+	read := func() string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(dir, "main.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	if err := source.refreshCache(dir); err != nil {
+		t.Fatal(err)
+	}
+	remote.commit("Update", "main.go", "package main // v2\n")
+	if err := source.refreshCache(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "package main // v2\n" {
+		t.Errorf("cached checkout not updated: %q", got)
+	}
+
+	// A branch that is gone from the remote forces a new clone.
+	head, err := remote.repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainRef := plumbing.NewBranchReferenceName("main")
+	if err := remote.repo.Storer.SetReference(plumbing.NewHashReference(mainRef, head.Hash())); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, mainRef)); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.repo.Storer.RemoveReference(head.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.refreshCache(dir); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head, err := repo.Head(); err != nil || head.Name().Short() != "main" {
+		t.Errorf("checkout is on %v (%v), want the new default branch", head, err)
+	}
+
+	// An unreachable remote leaves the checkout as it is.
+	if err := os.RemoveAll(remote.dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.refreshCache(dir); err != nil {
+		t.Fatalf("offline refresh failed: %v", err)
+	}
+	if got := read(); got != "package main // v2\n" {
+		t.Errorf("stale checkout was not kept: %q", got)
+	}
+}
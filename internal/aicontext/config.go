@@ -0,0 +1,167 @@
+package aicontext
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	repoConfigFile = ".aicontext.yaml"
+	repoIgnoreFile = ".aicontextignore"
+)
+
+// Setting sources in decreasing order of precedence.
+const (
+	sourceCLI     = "cli"
+	sourceRepo    = "repo"
+	sourceUser    = "user"
+	sourceDefault = "default"
+)
+
+var sourceOrder = []string{sourceCLI, sourceRepo, sourceUser, sourceDefault}
+
+// FileConfig is the schema of both .aicontext.yaml at the root of a processed
+// directory and the user config at ~/.config/ai-context/config.yaml.
+type FileConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	MaxSize *int64   `yaml:"max-size"`
+	Format  string   `yaml:"format"`
+	// Template is relative to the directory of the config file.
+	Template string `yaml:"template"`
+}
+
+func userConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "ai-context", "config.yaml")
+}
+
+func loadFileConfig(path string) (*FileConfig, error) {
+	config := &FileConfig{}
+	if path == "" {
+		return config, nil
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// applyConfigFiles merges the repo and user config files into p.config and
+// rebuilds the path filter. For every setting the first source that sets it
+// wins, in the order CLI > repo file > user config > built-in default.
+func (p *Processor) applyConfigFiles(root string) error {
+	userConfig, err := loadFileConfig(userConfigPath())
+	if err != nil {
+		return err
+	}
+	// Sources that are not directories, such as pull requests, have no
+	// repo config.
+	repoConfigPath := ""
+	if root != "" {
+		repoConfigPath = filepath.Join(root, repoConfigFile)
+	}
+	repoConfig, err := loadFileConfig(repoConfigPath)
+	if err != nil {
+		return err
+	}
+
+	var source, formatSource, templateSource string
+	p.config.IncludeGlobs, source = resolveSetting(p.config.FlagsSet["include"], p.config.IncludeGlobs,
+		repoConfig.Include, len(repoConfig.Include) > 0, userConfig.Include, len(userConfig.Include) > 0)
+	logSetting(root, "include", source, p.config.IncludeGlobs)
+	p.config.ExcludeGlobs, source = resolveSetting(p.config.FlagsSet["exclude"], p.config.ExcludeGlobs,
+		repoConfig.Exclude, len(repoConfig.Exclude) > 0, userConfig.Exclude, len(userConfig.Exclude) > 0)
+	logSetting(root, "exclude", source, p.config.ExcludeGlobs)
+	p.config.MaxSize, source = resolveSetting(p.config.FlagsSet["max-size"], p.config.MaxSize,
+		derefOr(repoConfig.MaxSize, 0), repoConfig.MaxSize != nil, derefOr(userConfig.MaxSize, 0), userConfig.MaxSize != nil)
+	logSetting(root, "max-size", source, p.config.MaxSize)
+	p.config.Format, formatSource = resolveSetting(p.config.FlagsSet["format"], p.config.Format,
+		repoConfig.Format, repoConfig.Format != "", userConfig.Format, userConfig.Format != "")
+	logSetting(root, "format", formatSource, p.config.Format)
+	if err := ValidateFormat(p.config.Format); err != nil {
+		return err
+	}
+	p.config.Template, templateSource = resolveSetting(p.config.FlagsSet["template"], p.config.Template,
+		relativeTo(root, repoConfig.Template), repoConfig.Template != "",
+		relativeTo(filepath.Dir(userConfigPath()), userConfig.Template), userConfig.Template != "")
+	logSetting(root, "template", templateSource, p.config.Template)
+	// Format and template both pick the renderer, so one source must not
+	// set both; across sources the one with higher precedence wins.
+	if formatSource != sourceDefault && templateSource != sourceDefault {
+		switch {
+		case formatSource == sourceRepo && templateSource == sourceRepo:
+			return fmt.Errorf("config %s sets both format and template", repoConfigPath)
+		case formatSource == sourceUser && templateSource == sourceUser:
+			return fmt.Errorf("config %s sets both format and template", userConfigPath())
+		case formatSource == templateSource:
+			return fmt.Errorf("format and template cannot be used together")
+		}
+		if slices.Index(sourceOrder, formatSource) < slices.Index(sourceOrder, templateSource) {
+			log.Debug().Str("package", "aicontext").Str("path", root).Str("template", p.config.Template).Msg("template overridden by format")
+			p.config.Template = ""
+		}
+	}
+	if p.config.Template != "" {
+		if p.template, err = loadTemplate(p.config.Template); err != nil {
+			return err
+		}
+	}
+
+	p.filter = newPathFilter(p.config)
+	if root != "" {
+		p.filter.loadProjectIgnores(filepath.Join(root, repoIgnoreFile))
+	}
+	return nil
+}
+
+func resolveSetting[T any](cliSet bool, cliValue T, repoValue T, repoSet bool, userValue T, userSet bool) (T, string) {
+	switch {
+	case cliSet:
+		return cliValue, sourceCLI
+	case repoSet:
+		return repoValue, sourceRepo
+	case userSet:
+		return userValue, sourceUser
+	default:
+		return cliValue, sourceDefault
+	}
+}
+
+func relativeTo(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func derefOr[T any](ptr *T, fallback T) T {
+	if ptr == nil {
+		return fallback
+	}
+	return *ptr
+}
+
+func logSetting(root string, name string, source string, value any) {
+	log.Debug().
+		Str("package", "aicontext").
+		Str("path", root).
+		Str("setting", name).
+		Str("source", source).
+		Interface("value", value).
+		Msg("resolved setting (precedence: cli > repo > user > default)")
+}
@@ -0,0 +1,134 @@
+package aicontext
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyConfigFilesPrecedence(t *testing.T) {
+	const defaultMaxSize = 1024
+	tests := []struct {
+		name        string
+		userConfig  string
+		repoConfig  string
+		flags       ProcessorConfig
+		wantMaxSize int64
+		wantFormat  string
+		wantInclude []string
+	}{
+		{
+			name:        "default",
+			wantMaxSize: defaultMaxSize, wantFormat: "markdown",
+		},
+		{
+			name:        "user over default",
+			userConfig:  "max-size: 10\nformat: xml\ninclude: [\"*.go\"]\n",
+			wantMaxSize: 10, wantFormat: "xml", wantInclude: []string{"*.go"},
+		},
+		{
+			name:        "repo over user",
+			userConfig:  "max-size: 10\nformat: xml\ninclude: [\"*.go\"]\n",
+			repoConfig:  "max-size: 20\nformat: json\n",
+			wantMaxSize: 20, wantFormat: "json", wantInclude: []string{"*.go"},
+		},
+		{
+			name:        "cli over repo",
+			userConfig:  "max-size: 10\nformat: xml\n",
+			repoConfig:  "max-size: 20\nformat: json\ninclude: [\"*.md\"]\n",
+			flags:       ProcessorConfig{MaxSize: 30, FlagsSet: map[string]bool{"max-size": true}},
+			wantMaxSize: 30, wantFormat: "json", wantInclude: []string{"*.md"},
+		},
+		{
+			name:        "zero from repo is set",
+			userConfig:  "max-size: 10\n",
+			repoConfig:  "max-size: 0\n",
+			wantMaxSize: 0, wantFormat: "markdown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeConfigs(t, tt.userConfig, tt.repoConfig)
+			config := tt.flags
+			if config.FlagsSet == nil {
+				config.MaxSize = defaultMaxSize
+			}
+			config.Format = "markdown"
+			p := NewProcessor(config)
+			if err := p.applyConfigFiles(root); err != nil {
+				t.Fatal(err)
+			}
+			if p.config.MaxSize != tt.wantMaxSize || p.config.Format != tt.wantFormat || !slices.Equal(p.config.IncludeGlobs, tt.wantInclude) {
+				t.Errorf("got max-size %d, format %q, include %v; want %d, %q, %v",
+					p.config.MaxSize, p.config.Format, p.config.IncludeGlobs, tt.wantMaxSize, tt.wantFormat, tt.wantInclude)
+			}
+		})
+	}
+}
+
+func TestApplyConfigFilesFormatAndTemplate(t *testing.T) {
+	tests := []struct {
+		name         string
+		userConfig   string
+		repoConfig   string
+		flags        ProcessorConfig
+		wantErr      string
+		wantTemplate bool
+	}{
+		{name: "repo sets both", repoConfig: "format: xml\ntemplate: out.md.tmpl\n", wantErr: repoConfigFile + " sets both"},
+		{name: "user sets both", userConfig: "format: xml\ntemplate: out.md.tmpl\n", wantErr: "config.yaml sets both"},
+		{name: "repo template over user format", userConfig: "format: xml\n", repoConfig: "template: out.md.tmpl\n", wantTemplate: true},
+		{name: "repo format over user template", userConfig: "template: out.md.tmpl\n", repoConfig: "format: xml\n"},
+		{name: "cli format over repo template", repoConfig: "template: out.md.tmpl\n", flags: ProcessorConfig{Format: "json", FlagsSet: map[string]bool{"format": true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeConfigs(t, tt.userConfig, tt.repoConfig)
+			p := NewProcessor(tt.flags)
+			err := p.applyConfigFiles(root)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, isTemplate := p.renderer().(templateRenderer); isTemplate != tt.wantTemplate {
+				t.Errorf("renderer %T, want template %v", p.renderer(), tt.wantTemplate)
+			}
+		})
+	}
+}
+
+// writeConfigs sets HOME to a temporary directory with the given user
+// config and returns a directory with the given repo config. Each config
+// directory has a template named out.md.tmpl.
+func writeConfigs(t *testing.T, userConfig string, repoConfig string) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	for _, dir := range []string{filepath.Dir(userConfigPath()), root} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "out.md.tmpl"), []byte("{{len .Files}}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if userConfig != "" {
+		if err := os.WriteFile(userConfigPath(), []byte(userConfig), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if repoConfig != "" {
+		if err := os.WriteFile(filepath.Join(root, repoConfigFile), []byte(repoConfig), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
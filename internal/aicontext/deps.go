@@ -0,0 +1,154 @@
+package aicontext
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/mod/module"
+)
+
+// addDependencies appends the files of the Go modules required by the
+// go.mod of root, or of its closest parent up to configRoot, that match
+// --with-deps. Their paths are prefixed with the module and version and
+// they are marked with Dependency, so that renderers list them in a
+// "Dependencies" section after the project's files.
+func (p *Processor) addDependencies(configRoot string, root string, output *Output) error {
+	goModPath := findGoMod(configRoot, root)
+	if goModPath == "" {
+		log.Warn().Str("package", "aicontext").Str("root", root).Msg("--with-deps: no go.mod found")
+		return nil
+	}
+	file, err := readGoMod(goModPath)
+	if err != nil {
+		return err
+	}
+	cacheDir := goModCache()
+	matched := make(map[string]bool)
+	for _, req := range file.Require {
+		pattern, sub, ok := matchDependency(p.config.WithDeps, req.Mod.Path)
+		if !ok {
+			continue
+		}
+		matched[pattern] = true
+		resolved, dir := resolveRequirement(file, filepath.Dir(goModPath), req.Mod.Path)
+		label := req.Mod.Path
+		if dir == "" {
+			label += "@" + resolved.Version
+			if dir, err = moduleCacheDir(cacheDir, resolved); err != nil {
+				return err
+			}
+		}
+		dir = filepath.Join(dir, filepath.FromSlash(sub))
+		if _, err := os.Stat(dir); err != nil {
+			log.Warn().Str("package", "aicontext").Str("module", label).Str("cache", cacheDir).Msg("--with-deps: module not found in the module cache, run go mod download")
+			continue
+		}
+		if err := p.addDependency(dir, label, path.Join(label, sub), output); err != nil {
+			return fmt.Errorf("failed to process dependency %s: %w", label, err)
+		}
+	}
+	for _, pattern := range p.config.WithDeps {
+		if !matched[pattern] {
+			log.Warn().Str("package", "aicontext").Str("pattern", pattern).Str("gomod", goModPath).Msg("--with-deps: pattern matches no required module")
+		}
+	}
+	output.updateTotals()
+	return nil
+}
+
+// addDependency processes one module or package directory with the same
+// filters and limits as the project, apart from its .gitignore and
+// .aicontextignore, and adds its files below prefix.
+func (p *Processor) addDependency(dir string, label string, prefix string, output *Output) error {
+	sub := &Processor{
+		config:     p.config,
+		filter:     newPathFilter(p.config),
+		transport:  p.transport,
+		imageStore: p.imageStore,
+	}
+	depOutput, err := sub.processDirectory(dir)
+	if err != nil {
+		return err
+	}
+	p.imageStore = sub.imageStore
+	log.Debug().Str("package", "aicontext").Str("module", label).Int("files", len(depOutput.Files)).Msg("added dependency")
+	for _, entry := range depOutput.Files {
+		entry.Path = path.Join(prefix, filepath.ToSlash(entry.Path))
+		entry.Dependency = label
+		output.Files = append(output.Files, entry)
+	}
+	for _, image := range depOutput.Images {
+		image.Path = path.Join(prefix, image.Path)
+		output.Images = append(output.Images, image)
+	}
+	return nil
+}
+
+// matchDependency returns the first pattern selecting modulePath, with the
+// package directory inside the module when the pattern names one. Patterns
+// are globs over path segments like --include, e.g. github.com/spf13/* or
+// golang.org/x/**.
+func matchDependency(patterns []string, modulePath string) (string, string, bool) {
+	for _, pattern := range patterns {
+		if matchSegments(strings.Split(pattern, "/"), strings.Split(modulePath, "/")) {
+			return pattern, "", true
+		}
+		if sub, ok := strings.CutPrefix(pattern, modulePath+"/"); ok && !strings.ContainsAny(sub, "*?[") {
+			return pattern, sub, true
+		}
+	}
+	return "", "", false
+}
+
+// findGoMod returns the go.mod of root or of the closest parent directory
+// that is still inside configRoot.
+func findGoMod(configRoot string, root string) string {
+	dir := root
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		dir = filepath.Dir(root)
+	}
+	for {
+		candidate := filepath.Join(dir, "go.mod")
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+		rel, err := filepath.Rel(configRoot, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return ""
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// goModCache returns GOMODCACHE as the go command resolves it, falling back
+// to its default below GOPATH when go is not installed.
+func goModCache() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	if out, err := exec.Command("go", "env", "GOMODCACHE").Output(); err == nil {
+		if dir := strings.TrimSpace(string(out)); dir != "" {
+			return dir
+		}
+	}
+	return filepath.Join(filepath.SplitList(build.Default.GOPATH)[0], "pkg", "mod")
+}
+
+// moduleCacheDir returns where the go command extracts a module version.
+func moduleCacheDir(cacheDir string, version module.Version) (string, error) {
+	escapedPath, err := module.EscapePath(version.Path)
+	if err != nil {
+		return "", err
+	}
+	escapedVersion, err := module.EscapeVersion(version.Version)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, filepath.FromSlash(escapedPath)+"@"+escapedVersion), nil
+}
@@ -0,0 +1,219 @@
+package aicontext
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ChangeSet is what changed between a base revision and HEAD, rendered as
+// the "Changes" section in diff mode.
+type ChangeSet struct {
+	Base       string       `json:"base"`
+	BaseCommit string       `json:"base_commit"`
+	Head       string       `json:"head"`
+	HeadCommit string       `json:"head_commit"`
+	Commits    []CommitInfo `json:"commits,omitempty"`
+	Files      []FileChange `json:"files"`
+}
+
+type CommitInfo struct {
+	Hash    string `json:"hash"`
+	Author  string `json:"author"`
+	Date    string `json:"date"`
+	Subject string `json:"subject"`
+	Body    string `json:"body,omitempty"`
+}
+
+// FileChange is the unified diff of one file. Status is added, modified,
+// deleted or renamed; From is the old path of renamed files.
+type FileChange struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	From   string `json:"from,omitempty"`
+	Diff   string `json:"diff"`
+}
+
+// HandleDiff writes diff-mode context for the git repository containing
+// path, comparing HEAD with base.
+func HandleDiff(path string, base string, neighbours bool, config ProcessorConfig, output string) error {
+	target, err := newOutputTarget(output, 1)
+	if err != nil {
+		return err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	config.OutputPath, config.FixedOutputPath = target.pathFor("diff", filepath.Base(absPath))
+	return NewProcessor(config).ProcessDiff(path, base, neighbours)
+}
+
+// ProcessDiff emits the changes between base and HEAD together with the
+// contents at HEAD of the touched files and, with neighbours, of the other
+// files in their directories. Uncommitted edits are not included. The usual
+// filters still apply to the files.
+func (p *Processor) ProcessDiff(repoPath string, base string, neighbours bool) error {
+	repo, err := git.PlainOpenWithOptions(repoPath, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return fmt.Errorf("failed to open git repository: %w", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	root := worktree.Filesystem.Root()
+	changes, headTree, err := diffChanges(repo, base)
+	if err != nil {
+		return err
+	}
+	p.changes = changes
+	p.headTree = headTree
+	p.onlyPaths = make(map[string]bool)
+	for _, change := range changes.Files {
+		if change.Status == "deleted" {
+			continue
+		}
+		p.onlyPaths[filepath.FromSlash(change.Path)] = true
+		if neighbours {
+			addNeighbours(p.onlyPaths, headTree, path.Dir(change.Path))
+		}
+	}
+	return p.processPath(root, root)
+}
+
+// addNeighbours adds the files of dir in HEAD's tree.
+func addNeighbours(paths map[string]bool, headTree *object.Tree, dir string) {
+	tree := headTree
+	if dir != "." {
+		var err error
+		if tree, err = headTree.Tree(dir); err != nil {
+			return
+		}
+	}
+	for _, entry := range tree.Entries {
+		if entry.Mode.IsFile() {
+			paths[filepath.Join(filepath.FromSlash(dir), entry.Name)] = true
+		}
+	}
+}
+
+// diffChanges compares HEAD with the merge base of HEAD and base, as
+// `git diff base...HEAD` does, and lists the commits in between. It also
+// returns HEAD's tree, which the touched files are read from.
+func diffChanges(repo *git.Repository, base string) (*ChangeSet, *object.Tree, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	baseHash, err := repo.ResolveRevision(plumbing.Revision(base))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve base %q: %w", base, err)
+	}
+	baseCommit, err := repo.CommitObject(*baseHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	mergeBases, err := baseCommit.MergeBase(headCommit)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find the merge base of %s and HEAD: %w", base, err)
+	}
+	if len(mergeBases) == 0 {
+		return nil, nil, fmt.Errorf("%s and HEAD have no common history", base)
+	}
+	baseCommit = mergeBases[0]
+
+	changes := &ChangeSet{
+		Base:       base,
+		BaseCommit: baseCommit.Hash.String()[:7],
+		Head:       "HEAD",
+		HeadCommit: headCommit.Hash.String()[:7],
+	}
+	if head.Name().IsBranch() {
+		changes.Head = head.Name().Short()
+	}
+
+	var commits []*object.Commit
+	err = object.NewCommitPreorderIter(headCommit, nil, []plumbing.Hash{baseCommit.Hash}).ForEach(func(c *object.Commit) error {
+		commits = append(commits, c)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	sort.SliceStable(commits, func(i, j int) bool { return commits[i].Committer.When.After(commits[j].Committer.When) })
+	for _, c := range commits {
+		changes.Commits = append(changes.Commits, commitInfo(c))
+	}
+
+	patch, err := baseCommit.Patch(headCommit)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to diff %s and %s: %w", changes.BaseCommit, changes.HeadCommit, err)
+	}
+	for _, filePatch := range patch.FilePatches() {
+		change, err := fileChange(filePatch)
+		if err != nil {
+			return nil, nil, err
+		}
+		changes.Files = append(changes.Files, change)
+	}
+	sort.Slice(changes.Files, func(i, j int) bool { return changes.Files[i].Path < changes.Files[j].Path })
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the tree of HEAD: %w", err)
+	}
+	return changes, headTree, nil
+}
+
+func commitInfo(c *object.Commit) CommitInfo {
+	subject, body, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+	return CommitInfo{
+		Hash:    c.Hash.String()[:7],
+		Author:  c.Author.Name,
+		Date:    c.Author.When.Format(time.DateOnly),
+		Subject: subject,
+		Body:    strings.TrimSpace(body),
+	}
+}
+
+// singlePatch lets the unified encoder write one file's diff.
+type singlePatch struct {
+	filePatch diff.FilePatch
+}
+
+func (p singlePatch) FilePatches() []diff.FilePatch { return []diff.FilePatch{p.filePatch} }
+func (p singlePatch) Message() string               { return "" }
+
+func fileChange(filePatch diff.FilePatch) (FileChange, error) {
+	var change FileChange
+	from, to := filePatch.Files()
+	switch {
+	case from == nil:
+		change.Path, change.Status = to.Path(), "added"
+	case to == nil:
+		change.Path, change.Status = from.Path(), "deleted"
+	case from.Path() != to.Path():
+		change.Path, change.Status, change.From = to.Path(), "renamed", from.Path()
+	default:
+		change.Path, change.Status = to.Path(), "modified"
+	}
+	var buf bytes.Buffer
+	if err := diff.NewUnifiedEncoder(&buf, diff.DefaultContextLines).Encode(singlePatch{filePatch}); err != nil {
+		return change, fmt.Errorf("failed to encode diff of %s: %w", change.Path, err)
+	}
+	change.Diff = buf.String()
+	return change, nil
+}
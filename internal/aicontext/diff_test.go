@@ -0,0 +1,139 @@
+package aicontext
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// testRepo is a repository in a temporary directory with helpers to write
+// and commit files.
+type testRepo struct {
+	t        *testing.T
+	dir      string
+	repo     *git.Repository
+	worktree *git.Worktree
+	clock    time.Time
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{t: t, dir: dir, repo: repo, worktree: worktree, clock: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (r *testRepo) write(name string, content string) {
+	r.t.Helper()
+	target := filepath.Join(r.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte(content), 0o644); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// commit writes files, given as name and content pairs, and commits them.
+func (r *testRepo) commit(message string, files ...string) plumbing.Hash {
+	r.t.Helper()
+	for i := 0; i < len(files); i += 2 {
+		r.write(files[i], files[i+1])
+		if _, err := r.worktree.Add(files[i]); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	r.clock = r.clock.Add(time.Hour)
+	hash, err := r.worktree.Commit(message, &git.CommitOptions{Author: &object.Signature{Name: "Tester", Email: "t@example.com", When: r.clock}})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return hash
+}
+
+func (r *testRepo) checkout(branch string, create bool) {
+	r.t.Helper()
+	if err := r.worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: create, Keep: true}); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func TestProcessDiff(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit("Initial", "main.go", "package main\n", "pkg/util.go", "package pkg\n", "pkg/other.go", "package pkg // other\n")
+	r.checkout("feature", true)
+	r.commit("Change util\n\nWith details.", "pkg/util.go", "package pkg\n\nfunc Util() {}\n", "pkg/new.go", "package pkg // new\n")
+	// Uncommitted edits and untracked files are not part of base...HEAD.
+	r.write("pkg/util.go", "package pkg\n\nfunc Util() { panic(\"wip\") }\n")
+	r.write("pkg/other.go", "package pkg // edited\n")
+	r.write("pkg/untracked.go", "package pkg // untracked\n")
+
+	p := NewProcessor(ProcessorConfig{})
+	output := processToJSON(t, p, func() error { return p.ProcessDiff(r.dir, "master", true) })
+
+	changes := output.Changes
+	if changes == nil || changes.Base != "master" || changes.Head != "feature" {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+	if len(changes.Commits) != 1 || changes.Commits[0].Subject != "Change util" || changes.Commits[0].Body != "With details." {
+		t.Errorf("unexpected commits: %+v", changes.Commits)
+	}
+	if len(changes.Files) != 2 || changes.Files[0].Path != "pkg/new.go" || changes.Files[0].Status != "added" ||
+		changes.Files[1].Path != "pkg/util.go" || changes.Files[1].Status != "modified" || !strings.Contains(changes.Files[1].Diff, "+func Util() {}") {
+		t.Errorf("unexpected file changes: %+v", changes.Files)
+	}
+
+	want := map[string]string{
+		"pkg/new.go":   "package pkg // new\n",
+		"pkg/other.go": "package pkg // other\n",
+		"pkg/util.go":  "package pkg\n\nfunc Util() {}\n",
+	}
+	if len(output.Files) != len(want) {
+		t.Errorf("got %d files, want %d: %+v", len(output.Files), len(want), output.Files)
+	}
+	for _, file := range output.Files {
+		if content, ok := want[filepath.ToSlash(file.Path)]; !ok || file.Content != content {
+			t.Errorf("%s = %q, want the HEAD version %q", file.Path, file.Content, content)
+		}
+	}
+}
+
+func TestProcessDiffUnrelatedBase(t *testing.T) {
+	r := newTestRepo(t)
+	head := r.commit("Initial", "main.go", "package main\n")
+	headCommit, err := r.repo.CommitObject(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan := &object.Commit{Author: headCommit.Author, Committer: headCommit.Committer, Message: "Orphan", TreeHash: headCommit.TreeHash}
+	obj := r.repo.Storer.NewEncodedObject()
+	if err := orphan.Encode(obj); err != nil {
+		t.Fatal(err)
+	}
+	orphanHash, err := r.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("orphan"), orphanHash)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	p := NewProcessor(ProcessorConfig{OutputPath: filepath.Join(t.TempDir(), "out.md"), FixedOutputPath: true})
+	if err := p.ProcessDiff(r.dir, "orphan", false); err == nil || !strings.Contains(err.Error(), "no common history") {
+		t.Errorf("got error %v, want no common history", err)
+	}
+}
@@ -0,0 +1,444 @@
+package aicontext
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// documentExtractor turns a document into Markdown.
+type documentExtractor func(p *Processor, content []byte) (string, error)
+
+// documentExtractors are applied by extension with --extract-docs. The
+// extracted files keep their path and are marked as derived from the
+// original format.
+var documentExtractors = map[string]documentExtractor{
+	".pdf":   (*Processor).extractPDF,
+	".docx":  (*Processor).extractDOCX,
+	".ipynb": (*Processor).extractNotebook,
+}
+
+var ansiEscapeRegex = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// isDocumentFile reports whether filePath has an extractor, so that
+// defaultExcludes let it through with --extract-docs.
+func isDocumentFile(filePath string) bool {
+	_, ok := documentExtractors[strings.ToLower(path.Ext(filePath))]
+	return ok
+}
+
+// extractDocument converts a document for processDirectory. It returns ok
+// false for files that are not documents or without --extract-docs.
+func (p *Processor) extractDocument(relPath string, content []byte) (entry FileEntry, ok bool, err error) {
+	ext := strings.ToLower(path.Ext(relPath))
+	extract, found := documentExtractors[ext]
+	if !p.config.ExtractDocs || !found {
+		return FileEntry{}, false, nil
+	}
+	text, err := extract(p, content)
+	if err != nil {
+		return FileEntry{}, true, err
+	}
+	return FileEntry{
+		Path:     relPath,
+		Content:  text,
+		Language: "markdown",
+		Size:     int64(len(text)),
+		Tokens:   countTokens(text),
+		Derived:  strings.TrimPrefix(ext, "."),
+	}, true, nil
+}
+
+// extractPDF returns the text of every page under a "## Page N" heading.
+// Scanned PDFs without a text layer come out empty.
+func (p *Processor) extractPDF(content []byte) (text string, err error) {
+	// The pdf package reports malformed objects by panicking, and resolves
+	// most of them lazily while pages are read.
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = "", fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+	reader, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("failed to read pdf: %w", err)
+	}
+	var sb strings.Builder
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		pageText, err := page.GetPlainText(nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to read page %d: %w", i, err)
+		}
+		if pageText = strings.TrimSpace(pageText); pageText != "" {
+			fmt.Fprintf(&sb, "## Page %d\n\n%s\n\n", i, pageText)
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n"), nil
+}
+
+// docxParagraph collects the text of one <w:p> and how to render it.
+type docxParagraph struct {
+	text    strings.Builder
+	heading int
+	list    bool
+}
+
+// extractDOCX converts word/document.xml to Markdown: headings, list items,
+// tables as pipe tables and, with --images download, embedded pictures.
+func (p *Processor) extractDOCX(content []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open docx: %w", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+	document, ok := files["word/document.xml"]
+	if !ok {
+		return "", fmt.Errorf("word/document.xml not found")
+	}
+	relationships := docxRelationships(files["word/_rels/document.xml.rels"])
+
+	rc, err := document.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	decoder := xml.NewDecoder(rc)
+
+	var sb strings.Builder
+	var para *docxParagraph
+	var cell, row []string
+	var rows [][]string
+	tableDepth := 0
+	inText, inList := false, false
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse docx: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tbl":
+				tableDepth++
+			case "tr":
+				if tableDepth == 1 {
+					row = nil
+				}
+			case "tc":
+				if tableDepth == 1 {
+					cell = nil
+				}
+			case "p":
+				para = &docxParagraph{}
+			case "pStyle":
+				if para != nil {
+					style := strings.ToLower(docxAttr(t, "val"))
+					if level, found := strings.CutPrefix(style, "heading"); found && len(level) == 1 && level >= "1" && level <= "6" {
+						para.heading = int(level[0] - '0')
+					} else if style == "title" {
+						para.heading = 1
+					} else if strings.Contains(style, "list") {
+						para.list = true
+					}
+				}
+			case "numPr":
+				if para != nil {
+					para.list = true
+				}
+			case "t":
+				inText = true
+			case "tab":
+				if para != nil {
+					para.text.WriteString("\t")
+				}
+			case "br", "cr":
+				if para != nil {
+					para.text.WriteString("\n")
+				}
+			case "blip":
+				if para != nil {
+					if link := p.saveDOCXImage(files, relationships[docxAttr(t, "embed")]); link != "" {
+						fmt.Fprintf(&para.text, "![](%s)", link)
+					}
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if para == nil {
+					continue
+				}
+				text := strings.TrimSpace(para.text.String())
+				if inList && tableDepth == 0 && text != "" && !para.list {
+					sb.WriteString("\n")
+					inList = false
+				}
+				switch {
+				case tableDepth > 0:
+					if text != "" {
+						cell = append(cell, text)
+					}
+				case text == "":
+				case para.heading > 0:
+					fmt.Fprintf(&sb, "%s %s\n\n", strings.Repeat("#", para.heading), text)
+				case para.list:
+					fmt.Fprintf(&sb, "- %s\n", text)
+					inList = true
+				default:
+					fmt.Fprintf(&sb, "%s\n\n", text)
+				}
+				para = nil
+			case "tc":
+				// The paragraphs of a cell, including nested tables, are
+				// joined into one line.
+				if tableDepth == 1 {
+					row = append(row, strings.Join(cell, " "))
+				}
+			case "tr":
+				if tableDepth == 1 {
+					rows = append(rows, row)
+				}
+			case "tbl":
+				tableDepth--
+				if tableDepth == 0 {
+					if inList {
+						sb.WriteString("\n")
+						inList = false
+					}
+					writePipeTable(&sb, rows)
+					rows = nil
+				}
+			}
+		case xml.CharData:
+			if inText && para != nil {
+				para.text.Write(t)
+			}
+		}
+	}
+	return strings.TrimSpace(sb.String()) + "\n", nil
+}
+
+func docxAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// docxRelationships maps relationship IDs to their targets inside word/.
+func docxRelationships(file *zip.File) map[string]string {
+	targets := make(map[string]string)
+	if file == nil {
+		return targets
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return targets
+	}
+	defer rc.Close()
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xml.NewDecoder(rc).Decode(&rels); err != nil {
+		return targets
+	}
+	for _, rel := range rels.Relationships {
+		targets[rel.ID] = path.Join("word", rel.Target)
+	}
+	return targets
+}
+
+func (p *Processor) saveDOCXImage(files map[string]*zip.File, name string) string {
+	file, ok := files[name]
+	if p.config.Images != ImagesDownload || !ok {
+		return ""
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxImageBytes))
+	if err != nil {
+		return ""
+	}
+	return p.saveImage(name, "", data)
+}
+
+func writePipeTable(sb *strings.Builder, rows [][]string) {
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return
+	}
+	for i, row := range rows {
+		cells := make([]string, columns)
+		for j := range cells {
+			if j < len(row) {
+				cells[j] = strings.ReplaceAll(strings.ReplaceAll(row[j], "\n", " "), "|", "\\|")
+			}
+		}
+		fmt.Fprintf(sb, "| %s |\n", strings.Join(cells, " | "))
+		if i == 0 {
+			fmt.Fprintf(sb, "|%s\n", strings.Repeat(" --- |", columns))
+		}
+	}
+	sb.WriteString("\n")
+}
+
+// notebookText is a notebook string, stored either whole or as a list of
+// lines.
+type notebookText string
+
+func (t *notebookText) UnmarshalJSON(data []byte) error {
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*t = notebookText(strings.Join(lines, ""))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	*t = notebookText(text)
+	return nil
+}
+
+type notebook struct {
+	Metadata struct {
+		Kernelspec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+	Cells []struct {
+		CellType string       `json:"cell_type"`
+		Source   notebookText `json:"source"`
+		Outputs  []struct {
+			OutputType string                     `json:"output_type"`
+			Text       notebookText               `json:"text"`
+			Data       map[string]json.RawMessage `json:"data"`
+			Ename      string                     `json:"ename"`
+			Evalue     string                     `json:"evalue"`
+		} `json:"outputs"`
+	} `json:"cells"`
+}
+
+// extractNotebook writes the cells of a Jupyter notebook in order: markdown
+// cells as they are and code cells fenced in the kernel's language, each
+// followed by its text outputs with --notebook-outputs.
+func (p *Processor) extractNotebook(content []byte) (string, error) {
+	var nb notebook
+	if err := json.Unmarshal(content, &nb); err != nil {
+		return "", fmt.Errorf("failed to parse notebook: %w", err)
+	}
+	language := nb.Metadata.LanguageInfo.Name
+	if language == "" {
+		language = nb.Metadata.Kernelspec.Language
+	}
+	var sb strings.Builder
+	for i, cell := range nb.Cells {
+		source := strings.TrimRight(string(cell.Source), "\n")
+		switch cell.CellType {
+		case "markdown":
+			if source != "" {
+				fmt.Fprintf(&sb, "%s\n\n", source)
+			}
+			continue
+		case "code":
+			fmt.Fprintf(&sb, "### Cell %d\n\n", i+1)
+			fence := fenceFor(source)
+			fmt.Fprintf(&sb, "%s%s\n%s\n%s\n\n", fence, language, source, fence)
+		default:
+			fence := fenceFor(source)
+			fmt.Fprintf(&sb, "%s\n%s\n%s\n\n", fence, source, fence)
+			continue
+		}
+		if !p.config.NotebookOutputs {
+			continue
+		}
+		for _, output := range cell.Outputs {
+			var text string
+			switch output.OutputType {
+			case "stream":
+				text = string(output.Text)
+			case "execute_result", "display_data":
+				// A saved plot replaces its text representation.
+				text, _ = notebookData(output.Data, "text/plain")
+				for _, mediaType := range []string{"image/png", "image/jpeg", "image/svg+xml"} {
+					if data, ok := notebookData(output.Data, mediaType); ok && p.config.Images == ImagesDownload {
+						if link := p.saveNotebookImage(mediaType, data); link != "" {
+							fmt.Fprintf(&sb, "![output](%s)\n\n", link)
+							text = ""
+						}
+						break
+					}
+				}
+			case "error":
+				text = ansiEscapeRegex.ReplaceAllString(output.Ename+": "+output.Evalue, "")
+			}
+			if text = strings.TrimRight(text, "\n"); text != "" {
+				fence := fenceFor(text)
+				fmt.Fprintf(&sb, "Output:\n\n%s\n%s\n%s\n\n", fence, text, fence)
+			}
+		}
+	}
+	return strings.TrimSpace(sb.String()) + "\n", nil
+}
+
+// notebookData decodes one text or image representation of an output.
+// Other media types, such as application/json, hold objects and are never
+// decoded.
+func notebookData(data map[string]json.RawMessage, mediaType string) (string, bool) {
+	raw, ok := data[mediaType]
+	if !ok {
+		return "", false
+	}
+	var text notebookText
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return "", false
+	}
+	return string(text), true
+}
+
+func (p *Processor) saveNotebookImage(mediaType string, data string) string {
+	if mediaType == "image/svg+xml" {
+		return p.saveImage("", mediaType, []byte(data))
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(data, "\n", ""))
+	if err != nil {
+		return ""
+	}
+	return p.saveImage("", mediaType, decoded)
+}
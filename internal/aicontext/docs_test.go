@@ -0,0 +1,175 @@
+package aicontext
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExtractDOCX(t *testing.T) {
+	document := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+	<w:p><w:pPr><w:pStyle w:val="Title"/></w:pPr><w:r><w:t>Design</w:t></w:r></w:p>
+	<w:p><w:pPr><w:pStyle w:val="Heading2"/></w:pPr><w:r><w:t>Goals</w:t></w:r></w:p>
+	<w:p><w:r><w:t xml:space="preserve">Keep it </w:t></w:r><w:r><w:t>simple.</w:t></w:r></w:p>
+	<w:p><w:pPr><w:numPr><w:ilvl w:val="0"/></w:numPr></w:pPr><w:r><w:t>First</w:t></w:r></w:p>
+	<w:p><w:pPr><w:pStyle w:val="ListParagraph"/></w:pPr><w:r><w:t>Second</w:t></w:r></w:p>
+	<w:p/>
+	<w:tbl>
+		<w:tr><w:tc><w:p><w:r><w:t>Name</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Value</w:t></w:r></w:p></w:tc></w:tr>
+		<w:tr><w:tc><w:p><w:r><w:t>a|b</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>1</w:t></w:r></w:p><w:p><w:r><w:t>2</w:t></w:r></w:p></w:tc></w:tr>
+	</w:tbl>
+	<w:p><w:r><w:t>After</w:t><w:br/><w:t>break</w:t></w:r></w:p>
+</w:body></w:document>`
+	data := zipBytes(t, testEntry{name: "[Content_Types].xml", body: "<Types/>"}, testEntry{name: "word/document.xml", body: document})
+
+	p := NewProcessor(ProcessorConfig{})
+	got, err := p.extractDOCX(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# Design\n\n## Goals\n\nKeep it simple.\n\n- First\n- Second\n\n",
+		"| Name | Value |\n",
+		"| 1 2 |\n",
+		"After\nbreak\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("docx output lacks %q:\n%s", want, got)
+		}
+	}
+
+	if _, err := p.extractDOCX(zipBytes(t, testEntry{name: "word/other.xml", body: "<x/>"})); err == nil {
+		t.Error("docx without word/document.xml was accepted")
+	}
+	if _, err := p.extractDOCX([]byte("not a zip")); err == nil {
+		t.Error("invalid docx was accepted")
+	}
+}
+
+const testNotebook = `{
+	"metadata": {"kernelspec": {"language": "python"}, "language_info": {"name": "python"}},
+	"cells": [
+		{"cell_type": "markdown", "source": ["# Analysis\n", "Intro text."]},
+		{"cell_type": "code", "source": "print('hi')\n", "outputs": [
+			{"output_type": "stream", "name": "stdout", "text": ["hi\n"]}
+		]},
+		{"cell_type": "code", "source": ["df.head()"], "outputs": [
+			{"output_type": "execute_result", "data": {"text/plain": ["   a\n", "0  1"], "application/json": {"a": 1}}}
+		]},
+		{"cell_type": "code", "source": "1/0", "outputs": [
+			{"output_type": "error", "ename": "ZeroDivisionError", "evalue": "\u001b[31mdivision by zero\u001b[0m"}
+		]},
+		{"cell_type": "raw", "source": "raw text"}
+	]
+}`
+
+func TestExtractNotebook(t *testing.T) {
+	cells := "# Analysis\nIntro text.\n\n" +
+		"### Cell 2\n\n```python\nprint('hi')\n```\n\n"
+	tests := []struct {
+		name    string
+		outputs bool
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "without outputs",
+			want:    []string{cells, "### Cell 3\n\n```python\ndf.head()\n```\n\n### Cell 4\n\n```python\n1/0\n```\n\n```\nraw text\n```\n"},
+			notWant: []string{"Output:", "ZeroDivisionError"},
+		},
+		{
+			name:    "with outputs",
+			outputs: true,
+			want: []string{
+				cells + "Output:\n\n```\nhi\n```\n\n",
+				"```python\ndf.head()\n```\n\nOutput:\n\n```\n   a\n0  1\n```\n\n",
+				"Output:\n\n```\nZeroDivisionError: division by zero\n```\n\n",
+			},
+			notWant: []string{"\x1b[", `"a": 1`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProcessor(ProcessorConfig{NotebookOutputs: tt.outputs})
+			got, err := p.extractNotebook([]byte(testNotebook))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("notebook output lacks %q:\n%s", want, got)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("notebook output has %q:\n%s", notWant, got)
+				}
+			}
+		})
+	}
+
+	p := NewProcessor(ProcessorConfig{})
+	if _, err := p.extractNotebook([]byte(`{"cells": [`)); err == nil {
+		t.Error("truncated notebook was accepted")
+	}
+}
+
+// pdfBytes builds a PDF from the given objects, numbered from 1 with the
+// catalog first, and a cross-reference table that points at them.
+func pdfBytes(objects ...string) []byte {
+	var sb strings.Builder
+	sb.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = sb.Len()
+		fmt.Fprintf(&sb, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := sb.Len()
+	fmt.Fprintf(&sb, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&sb, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&sb, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return []byte(sb.String())
+}
+
+func TestExtractPDF(t *testing.T) {
+	catalog := "<< /Type /Catalog /Pages 2 0 R >>"
+	pages := "<< /Type /Pages /Kids [3 0 R] /Count 1 >>"
+	page := "<< /Type /Page /Parent 2 0 R /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>"
+	font := "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>"
+	stream := func(dict string, data string) string {
+		return fmt.Sprintf("<< /Length %d %s>>\nstream\n%s\nendstream", len(data), dict, data)
+	}
+
+	p := NewProcessor(ProcessorConfig{})
+	text, err := p.extractPDF(pdfBytes(catalog, pages, page, stream("", "BT /F1 12 Tf (Hello) Tj ET"), font))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "## Page 1\n\nHello\n" {
+		t.Errorf("got %q", text)
+	}
+
+	valid := string(pdfBytes(catalog, pages, page, stream("", "BT /F1 12 Tf (Hello) Tj ET"), font))
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"empty", nil},
+		{"not a pdf", []byte("hello world")},
+		{"header only", []byte("%PDF-1.7\n")},
+		{"truncated", []byte(valid[:len(valid)/2])},
+		{"no xref", []byte(valid[:strings.Index(valid, "xref")] + "trailer\n<< /Root 1 0 R >>\n%%EOF\n")},
+		{"contents not a stream", pdfBytes(catalog, pages, "<< /Type /Page /Parent 2 0 R /Contents 42 >>")},
+		{"corrupt compressed stream", pdfBytes(catalog, pages, page, stream("/Filter /FlateDecode ", "not zlib data"), font)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if text, err := p.extractPDF(tt.content); err == nil {
+				t.Errorf("got %q, want an error", text)
+			}
+		})
+	}
+}
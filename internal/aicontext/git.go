@@ -0,0 +1,178 @@
+package aicontext
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+var commitHashRegex = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+// gitSource is a repository to clone, narrowed down by the URL to a ref and
+// a sub-path. refPath holds the segments after /tree/ or /blob/ until they are
+// split into ref and sub-path against the refs of the remote.
+type gitSource struct {
+	cloneURL string
+	host     string
+	// repoPath is the repository's path on the host, without .git.
+	repoPath string
+	provider remoteProvider
+	ref      string
+	// refName is the branch or tag that ref names on the remote. It is
+	// empty when ref is a commit.
+	refName plumbing.ReferenceName
+	subPath string
+	refPath []string
+	// fullHistory clones the whole history instead of a single commit.
+	fullHistory bool
+	// authMethod caches the credentials that worked, so the clone reuses
+	// what ref resolution found.
+	authMethod transport.AuthMethod
+	// closeAuth closes the ssh-agent connection behind authMethod.
+	closeAuth func()
+}
+
+// resolveRefPath looks the ref up among the branches and tags of the remote.
+// refPath is split into a ref and a sub-path by picking the longest branch
+// or tag name that prefixes it, so refs with slashes like feature/login
+// work. A ref that names no branch or tag but looks like a commit hash is
+// used as a commit.
+func (s *gitSource) resolveRefPath(auth transport.AuthMethod) error {
+	if s.ref == "" && len(s.refPath) == 0 {
+		return nil
+	}
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: []string{s.cloneURL}})
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return fmt.Errorf("failed to list remote refs: %w", err)
+	}
+	names := make(map[string]plumbing.ReferenceName, len(refs))
+	for _, ref := range refs {
+		// Branches win over tags of the same name.
+		if _, ok := names[ref.Name().Short()]; ref.Name().IsBranch() || (ref.Name().IsTag() && !ok) {
+			names[ref.Name().Short()] = ref.Name()
+		}
+	}
+	return s.splitRefPath(names)
+}
+
+// splitRefPath resolves the ref against names, the remote's branches and
+// tags by short name.
+func (s *gitSource) splitRefPath(names map[string]plumbing.ReferenceName) error {
+	if len(s.refPath) == 0 {
+		if name, ok := names[s.ref]; ok {
+			s.refName = name
+			return nil
+		}
+		if commitHashRegex.MatchString(s.ref) {
+			return nil
+		}
+		return fmt.Errorf("no branch, tag or commit matches %q", s.ref)
+	}
+	for i := len(s.refPath); i > 0; i-- {
+		candidate := strings.Join(s.refPath[:i], "/")
+		if name, ok := names[candidate]; ok {
+			s.ref, s.refName, s.subPath = candidate, name, strings.Join(s.refPath[i:], "/")
+			return nil
+		}
+	}
+	if commitHashRegex.MatchString(s.refPath[0]) {
+		s.ref, s.subPath = s.refPath[0], strings.Join(s.refPath[1:], "/")
+		return nil
+	}
+	return fmt.Errorf("no branch, tag or commit matches %q", strings.Join(s.refPath, "/"))
+}
+
+// cloneSource clones s.ref (or the default branch) into dir. Branches and
+// tags, as resolved by resolveRefPath, are cloned shallowly unless
+// s.fullHistory is set; any other ref is a commit. Full commit hashes are
+// fetched directly, which GitHub allows for reachable commits; abbreviated
+// ones need a full clone to be resolved.
+func cloneSource(dir string, s *gitSource, auth transport.AuthMethod) error {
+	depth := s.depth()
+	if s.ref == "" {
+		_, err := git.PlainClone(dir, false, &git.CloneOptions{URL: s.cloneURL, Auth: auth, Depth: depth})
+		return err
+	}
+	if s.refName != "" {
+		_, err := git.PlainClone(dir, false, &git.CloneOptions{
+			URL:           s.cloneURL,
+			Auth:          auth,
+			Depth:         depth,
+			ReferenceName: s.refName,
+			SingleBranch:  true,
+		})
+		if err != nil {
+			return fmt.Errorf("ref %q: %w", s.ref, err)
+		}
+		return nil
+	}
+	if len(s.ref) == 40 && !s.fullHistory {
+		if err := fetchCommit(dir, s, auth); err == nil {
+			return nil
+		}
+		if err := resetDir(dir); err != nil {
+			return err
+		}
+	}
+	repo, err := git.PlainClone(dir, false, &git.CloneOptions{URL: s.cloneURL, Auth: auth, NoCheckout: true})
+	if err != nil {
+		return err
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(s.ref))
+	if err != nil {
+		return fmt.Errorf("failed to resolve commit %q: %w", s.ref, err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return worktree.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true})
+}
+
+func fetchCommit(dir string, s *gitSource, auth transport.AuthMethod) error {
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		return err
+	}
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{s.cloneURL}}); err != nil {
+		return err
+	}
+	err = repo.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		Auth:       auth,
+		Depth:      1,
+		RefSpecs:   []config.RefSpec{config.RefSpec(s.ref + ":refs/heads/aicontext")},
+	})
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return worktree.Checkout(&git.CheckoutOptions{Hash: plumbing.NewHash(s.ref), Force: true})
+}
+
+// depth is the clone depth, where 0 means the full history.
+func (s *gitSource) depth() int {
+	if s.fullHistory {
+		return 0
+	}
+	return 1
+}
+
+// resetDir empties dir after a failed clone attempt.
+func resetDir(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clean up clone directory: %w", err)
+	}
+	return os.MkdirAll(dir, 0755)
+}
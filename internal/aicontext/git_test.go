@@ -0,0 +1,112 @@
+package aicontext
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestSplitRefPath(t *testing.T) {
+	names := map[string]plumbing.ReferenceName{
+		"main":          plumbing.NewBranchReferenceName("main"),
+		"feature":       plumbing.NewBranchReferenceName("feature"),
+		"feature/login": plumbing.NewBranchReferenceName("feature/login"),
+		"v1.0":          plumbing.NewTagReferenceName("v1.0"),
+		"deadbeef":      plumbing.NewBranchReferenceName("deadbeef"),
+		"20240101":      plumbing.NewTagReferenceName("20240101"),
+	}
+	tests := []struct {
+		name        string
+		ref         string
+		refPath     string
+		wantRef     string
+		wantRefName plumbing.ReferenceName
+		wantSubPath string
+		wantErr     bool
+	}{
+		{name: "branch", refPath: "main/src/app", wantRef: "main", wantRefName: names["main"], wantSubPath: "src/app"},
+		{name: "longest ref wins", refPath: "feature/login/src", wantRef: "feature/login", wantRefName: names["feature/login"], wantSubPath: "src"},
+		{name: "shorter ref", refPath: "feature/other", wantRef: "feature", wantRefName: names["feature"], wantSubPath: "other"},
+		{name: "tag", refPath: "v1.0", wantRef: "v1.0", wantRefName: names["v1.0"]},
+		{name: "hex branch", refPath: "deadbeef/docs", wantRef: "deadbeef", wantRefName: names["deadbeef"], wantSubPath: "docs"},
+		{name: "numeric tag", refPath: "20240101", wantRef: "20240101", wantRefName: names["20240101"]},
+		{name: "commit", refPath: "0123456789abcdef/docs", wantRef: "0123456789abcdef", wantSubPath: "docs"},
+		{name: "unknown", refPath: "nope/docs", wantErr: true},
+		{name: "flag branch", ref: "feature/login", wantRef: "feature/login", wantRefName: names["feature/login"]},
+		{name: "flag hex branch", ref: "deadbeef", wantRef: "deadbeef", wantRefName: names["deadbeef"]},
+		{name: "flag numeric tag", ref: "20240101", wantRef: "20240101", wantRefName: names["20240101"]},
+		{name: "flag commit", ref: "1234567", wantRef: "1234567"},
+		{name: "flag unknown", ref: "nope", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &gitSource{ref: tt.ref}
+			if tt.refPath != "" {
+				s.refPath = strings.Split(tt.refPath, "/")
+			}
+			err := s.splitRefPath(names)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got ref %q, want an error", s.ref)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.ref != tt.wantRef || s.refName != tt.wantRefName || s.subPath != tt.wantSubPath {
+				t.Errorf("got %q (%q) and %q, want %q (%q) and %q", s.ref, s.refName, s.subPath, tt.wantRef, tt.wantRefName, tt.wantSubPath)
+			}
+		})
+	}
+}
+
+func TestCloneSourceRefs(t *testing.T) {
+	remote := newTestRepo(t)
+	first := remote.commit("Initial", "main.go", "package main // first\n")
+	remote.checkout("deadbeef", true)
+	remote.commit("On branch", "main.go", "package main // branch\n", "docs/a.md", "# A\n")
+	if _, err := remote.repo.CreateTag("1234567", first, nil); err != nil {
+		t.Fatal(err)
+	}
+	remote.checkout("master", false)
+
+	tests := []struct {
+		name    string
+		ref     string
+		refPath []string
+		want    string
+	}{
+		{"hex branch from flag", "deadbeef", nil, "package main // branch\n"},
+		{"hex branch from url", "", []string{"deadbeef", "docs"}, "package main // branch\n"},
+		{"numeric tag", "1234567", nil, "package main // first\n"},
+		{"commit", first.String()[:10], nil, "package main // first\n"},
+		{"full commit", first.String(), nil, "package main // first\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &gitSource{cloneURL: "file://" + filepath.ToSlash(remote.dir), host: "local", repoPath: "r", provider: genericProvider, ref: tt.ref, refPath: tt.refPath}
+			if err := source.withAuth(source.resolveRefPath); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			if err := source.cloneInto(dir); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(filepath.Join(dir, "main.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("checked out %q, want %q", content, tt.want)
+			}
+			if _, err := git.PlainOpen(dir); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,485 @@
+package aicontext
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultGitHubAPIURL is the REST API base used unless --github-api-url
+// points somewhere else, e.g. a GitHub Enterprise instance or a fake server.
+const DefaultGitHubAPIURL = "https://api.github.com"
+
+var githubDiscussionRegex = regexp.MustCompile(`^https://github\.com/([^/]+)/([^/]+)/(pull|issues)/(\d+)`)
+
+// Discussion is the conversation of a pull request or issue.
+type Discussion struct {
+	Kind      string              `json:"kind"`
+	Number    int                 `json:"number"`
+	Title     string              `json:"title"`
+	URL       string              `json:"url"`
+	Author    string              `json:"author"`
+	State     string              `json:"state"`
+	CreatedAt string              `json:"created_at"`
+	Body      string              `json:"body"`
+	Comments  []DiscussionComment `json:"comments,omitempty"`
+	Reviews   []ReviewThread      `json:"review_threads,omitempty"`
+}
+
+type DiscussionComment struct {
+	Author string `json:"author"`
+	Date   string `json:"date"`
+	// State is the verdict of a review summary, e.g. APPROVED.
+	State string `json:"state,omitempty"`
+	Body  string `json:"body"`
+}
+
+// ReviewThread is a review comment on a line of the diff with its replies.
+type ReviewThread struct {
+	Path     string              `json:"path"`
+	Line     int                 `json:"line,omitempty"`
+	DiffHunk string              `json:"diff_hunk"`
+	Comments []DiscussionComment `json:"comments"`
+}
+
+type ghUser struct {
+	Login string `json:"login"`
+}
+
+type ghIssue struct {
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	State     string    `json:"state"`
+	HTMLURL   string    `json:"html_url"`
+	User      ghUser    `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ghBranch struct {
+	Ref string `json:"ref"`
+	SHA string `json:"sha"`
+}
+
+type ghPullRequest struct {
+	ghIssue
+	Merged bool     `json:"merged"`
+	Head   ghBranch `json:"head"`
+	Base   ghBranch `json:"base"`
+}
+
+type ghComment struct {
+	ID          int64     `json:"id"`
+	InReplyToID int64     `json:"in_reply_to_id"`
+	User        ghUser    `json:"user"`
+	Body        string    `json:"body"`
+	State       string    `json:"state"`
+	CreatedAt   time.Time `json:"created_at"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	Path        string    `json:"path"`
+	Line        int       `json:"line"`
+	OrigLine    int       `json:"original_line"`
+	DiffHunk    string    `json:"diff_hunk"`
+}
+
+type ghCommit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name string    `json:"name"`
+			Date time.Time `json:"date"`
+		} `json:"author"`
+	} `json:"commit"`
+}
+
+type ghFile struct {
+	Filename         string `json:"filename"`
+	PreviousFilename string `json:"previous_filename"`
+	Status           string `json:"status"`
+	Patch            string `json:"patch"`
+}
+
+// githubAPI is the part of the GitHub REST API used for pull requests and
+// issues.
+type githubAPI interface {
+	Issue(owner, repo string, number int) (*ghIssue, error)
+	IssueComments(owner, repo string, number int) ([]ghComment, error)
+	PullRequest(owner, repo string, number int) (*ghPullRequest, error)
+	PullRequestCommits(owner, repo string, number int) ([]ghCommit, error)
+	PullRequestFiles(owner, repo string, number int) ([]ghFile, error)
+	Reviews(owner, repo string, number int) ([]ghComment, error)
+	ReviewComments(owner, repo string, number int) ([]ghComment, error)
+	FileContent(owner, repo, filePath, ref string) ([]byte, error)
+}
+
+// githubClient implements githubAPI over HTTP against baseURL.
+type githubClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func newGitHubClient(baseURL string, token string) *githubClient {
+	if baseURL == "" {
+		baseURL = DefaultGitHubAPIURL
+	}
+	return &githubClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *githubClient) request(rawURL string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			return nil, fmt.Errorf("github api: credentials rejected (check GH_TOKEN or GITHUB_TOKEN)")
+		case http.StatusNotFound:
+			return nil, fmt.Errorf("github api: %s not found (private repositories need GH_TOKEN or GITHUB_TOKEN)", strings.TrimPrefix(rawURL, c.baseURL))
+		}
+		return nil, fmt.Errorf("github api: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (c *githubClient) get(apiPath string, into any) error {
+	resp, err := c.request(c.baseURL+apiPath, "application/vnd.github+json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+		return fmt.Errorf("github api: failed to decode %s: %w", apiPath, err)
+	}
+	return nil
+}
+
+var nextLinkRegex = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// getPages collects every page of a list endpoint by following the Link
+// header.
+func getPages[T any](c *githubClient, apiPath string) ([]T, error) {
+	var all []T
+	next := c.baseURL + apiPath + "?per_page=100"
+	for next != "" {
+		resp, err := c.request(next, "application/vnd.github+json")
+		if err != nil {
+			return nil, err
+		}
+		var page []T
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("github api: failed to decode %s: %w", apiPath, err)
+		}
+		all = append(all, page...)
+		next = ""
+		if match := nextLinkRegex.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+			next = match[1]
+		}
+	}
+	return all, nil
+}
+
+func repoPath(owner, repo string) string {
+	return "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo)
+}
+
+func (c *githubClient) Issue(owner, repo string, number int) (*ghIssue, error) {
+	issue := &ghIssue{}
+	return issue, c.get(fmt.Sprintf("%s/issues/%d", repoPath(owner, repo), number), issue)
+}
+
+func (c *githubClient) IssueComments(owner, repo string, number int) ([]ghComment, error) {
+	return getPages[ghComment](c, fmt.Sprintf("%s/issues/%d/comments", repoPath(owner, repo), number))
+}
+
+func (c *githubClient) PullRequest(owner, repo string, number int) (*ghPullRequest, error) {
+	pull := &ghPullRequest{}
+	return pull, c.get(fmt.Sprintf("%s/pulls/%d", repoPath(owner, repo), number), pull)
+}
+
+func (c *githubClient) PullRequestCommits(owner, repo string, number int) ([]ghCommit, error) {
+	return getPages[ghCommit](c, fmt.Sprintf("%s/pulls/%d/commits", repoPath(owner, repo), number))
+}
+
+func (c *githubClient) PullRequestFiles(owner, repo string, number int) ([]ghFile, error) {
+	return getPages[ghFile](c, fmt.Sprintf("%s/pulls/%d/files", repoPath(owner, repo), number))
+}
+
+func (c *githubClient) Reviews(owner, repo string, number int) ([]ghComment, error) {
+	return getPages[ghComment](c, fmt.Sprintf("%s/pulls/%d/reviews", repoPath(owner, repo), number))
+}
+
+func (c *githubClient) ReviewComments(owner, repo string, number int) ([]ghComment, error) {
+	return getPages[ghComment](c, fmt.Sprintf("%s/pulls/%d/comments", repoPath(owner, repo), number))
+}
+
+func (c *githubClient) FileContent(owner, repo, filePath, ref string) ([]byte, error) {
+	segments := strings.Split(filePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	resp, err := c.request(fmt.Sprintf("%s%s/contents/%s?ref=%s", c.baseURL, repoPath(owner, repo), strings.Join(segments, "/"), url.QueryEscape(ref)), "application/vnd.github.raw")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (p *Processor) githubClient(rawURL string) githubAPI {
+	if p.github != nil {
+		return p.github
+	}
+	var token string
+	if source, err := parseGitURL(rawURL); err == nil {
+		token = source.token()
+	}
+	return newGitHubClient(p.config.GitHubAPIURL, token)
+}
+
+// ProcessGitHubDiscussion writes the conversation of a pull request or
+// issue. Pull requests also get their commits and per-file diffs as a
+// Changes section and the touched files as of the head commit.
+func (p *Processor) ProcessGitHubDiscussion(rawURL string) error {
+	match := githubDiscussionRegex.FindStringSubmatch(rawURL)
+	if match == nil {
+		return fmt.Errorf("not a pull request or issue url: %s", rawURL)
+	}
+	owner, repo, kind := match[1], match[2], match[3]
+	number, _ := strconv.Atoi(match[4])
+	if err := p.applyConfigFiles(""); err != nil {
+		return err
+	}
+	api := p.githubClient(rawURL)
+	output := &Output{GenerationDate: time.Now().Format(time.RFC3339), Files: make([]FileEntry, 0)}
+
+	var issue *ghIssue
+	var pull *ghPullRequest
+	var err error
+	if kind == "pull" {
+		if pull, err = api.PullRequest(owner, repo, number); err != nil {
+			return err
+		}
+		issue = &pull.ghIssue
+	} else if issue, err = api.Issue(owner, repo, number); err != nil {
+		return err
+	}
+	output.Discussion = &Discussion{
+		Kind:      "Issue",
+		Number:    issue.Number,
+		Title:     issue.Title,
+		URL:       issue.HTMLURL,
+		Author:    issue.User.Login,
+		State:     issue.State,
+		CreatedAt: issue.CreatedAt.Format(time.DateOnly),
+		Body:      strings.TrimSpace(issue.Body),
+	}
+	comments, err := api.IssueComments(owner, repo, number)
+	if err != nil {
+		return err
+	}
+	for _, comment := range comments {
+		output.Discussion.Comments = append(output.Discussion.Comments, discussionComment(comment, comment.CreatedAt))
+	}
+
+	if pull != nil {
+		output.Discussion.Kind = "Pull Request"
+		if pull.Merged {
+			output.Discussion.State = "merged"
+		}
+		if err := p.addPullRequest(api, owner, repo, pull, output); err != nil {
+			return err
+		}
+	}
+	sort.SliceStable(output.Discussion.Comments, func(i, j int) bool {
+		return output.Discussion.Comments[i].Date < output.Discussion.Comments[j].Date
+	})
+
+	output.updateTotals()
+	if err := p.applyTokenBudget(output); err != nil {
+		return fmt.Errorf("failed to apply token budget: %w", err)
+	}
+	return p.writeOutput(output)
+}
+
+func (p *Processor) addPullRequest(api githubAPI, owner, repo string, pull *ghPullRequest, output *Output) error {
+	reviews, err := api.Reviews(owner, repo, pull.Number)
+	if err != nil {
+		return err
+	}
+	for _, review := range reviews {
+		if strings.TrimSpace(review.Body) != "" || review.State == "APPROVED" || review.State == "CHANGES_REQUESTED" {
+			output.Discussion.Comments = append(output.Discussion.Comments, discussionComment(review, review.SubmittedAt))
+		}
+	}
+	reviewComments, err := api.ReviewComments(owner, repo, pull.Number)
+	if err != nil {
+		return err
+	}
+	output.Discussion.Reviews = reviewThreads(reviewComments)
+
+	changes := &ChangeSet{
+		Base:       pull.Base.Ref,
+		BaseCommit: shortHash(pull.Base.SHA),
+		Head:       pull.Head.Ref,
+		HeadCommit: shortHash(pull.Head.SHA),
+	}
+	commits, err := api.PullRequestCommits(owner, repo, pull.Number)
+	if err != nil {
+		return err
+	}
+	for i := len(commits) - 1; i >= 0; i-- {
+		commit := commits[i]
+		subject, body, _ := strings.Cut(strings.TrimSpace(commit.Commit.Message), "\n")
+		changes.Commits = append(changes.Commits, CommitInfo{
+			Hash:    shortHash(commit.SHA),
+			Author:  commit.Commit.Author.Name,
+			Date:    commit.Commit.Author.Date.Format(time.DateOnly),
+			Subject: subject,
+			Body:    strings.TrimSpace(body),
+		})
+	}
+	files, err := api.PullRequestFiles(owner, repo, pull.Number)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, file := range files {
+		change := pullFileChange(file)
+		changes.Files = append(changes.Files, change)
+		if change.Status == "deleted" || !p.filter.shouldIncludePath(file.Filename) {
+			continue
+		}
+		// A file that cannot be fetched, e.g. because it is too large for
+		// the contents API, only loses its content; its diff is kept.
+		content, err := api.FileContent(owner, repo, file.Filename, pull.Head.SHA)
+		if err != nil {
+			log.Debug().Str("package", "aicontext").Str("path", file.Filename).Err(err).Msg("skipping pull request file")
+			continue
+		}
+		if (p.config.MaxSize > 0 && int64(len(content)) > p.config.MaxSize) || isBinary(content) || !p.filter.shouldIncludeContent(content) {
+			continue
+		}
+		output.Files = append(output.Files, FileEntry{
+			Path:     file.Filename,
+			Content:  string(content),
+			Language: detectLanguage(file.Filename),
+			Size:     int64(len(content)),
+			Tokens:   countTokens(string(content)),
+		})
+		paths = append(paths, file.Filename)
+	}
+	output.Changes = changes
+	output.DirectoryTree = treeFromPaths(paths)
+	return nil
+}
+
+func discussionComment(comment ghComment, date time.Time) DiscussionComment {
+	return DiscussionComment{
+		Author: comment.User.Login,
+		Date:   date.Format(time.DateTime),
+		State:  comment.State,
+		Body:   strings.TrimSpace(comment.Body),
+	}
+}
+
+// reviewThreads groups review comments under the comment they reply to.
+func reviewThreads(comments []ghComment) []ReviewThread {
+	var threads []ReviewThread
+	index := make(map[int64]int)
+	for _, comment := range comments {
+		entry := discussionComment(comment, comment.CreatedAt)
+		if i, ok := index[comment.InReplyToID]; ok && comment.InReplyToID != 0 {
+			threads[i].Comments = append(threads[i].Comments, entry)
+			index[comment.ID] = i
+			continue
+		}
+		line := comment.Line
+		if line == 0 {
+			line = comment.OrigLine
+		}
+		index[comment.ID] = len(threads)
+		threads = append(threads, ReviewThread{Path: comment.Path, Line: line, DiffHunk: comment.DiffHunk, Comments: []DiscussionComment{entry}})
+	}
+	return threads
+}
+
+func pullFileChange(file ghFile) FileChange {
+	change := FileChange{Path: file.Filename, Status: file.Status}
+	from, to := "a/"+file.Filename, "b/"+file.Filename
+	switch file.Status {
+	case "added":
+		from = "/dev/null"
+	case "removed":
+		change.Status, to = "deleted", "/dev/null"
+	case "renamed":
+		change.From, from = file.PreviousFilename, "a/"+file.PreviousFilename
+	case "modified":
+	default:
+		change.Status = "modified"
+	}
+	if file.Patch == "" {
+		change.Diff = fmt.Sprintf("Binary or large file %s, no diff available\n", file.Filename)
+		return change
+	}
+	change.Diff = fmt.Sprintf("--- %s\n+++ %s\n%s\n", from, to, strings.TrimSuffix(file.Patch, "\n"))
+	return change
+}
+
+func shortHash(hash string) string {
+	return hash[:min(len(hash), 7)]
+}
+
+// treeFromPaths renders a directory tree, like generateDirectoryTree, for
+// files that do not exist on disk.
+func treeFromPaths(paths []string) string {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+	var tree strings.Builder
+	printed := make(map[string]bool)
+	for _, filePath := range sorted {
+		segments := strings.Split(filePath, "/")
+		for i := range segments {
+			prefix := path.Join(segments[:i+1]...)
+			if printed[prefix] {
+				continue
+			}
+			printed[prefix] = true
+			if i < len(segments)-1 {
+				fmt.Fprintf(&tree, "%s%s/\n", strings.Repeat("  ", i), segments[i])
+			} else {
+				fmt.Fprintf(&tree, "%s%s\n", strings.Repeat("  ", i), segments[i])
+			}
+		}
+	}
+	return tree.String()
+}
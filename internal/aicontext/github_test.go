@@ -0,0 +1,172 @@
+package aicontext
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// processToJSON runs process with a processor writing JSON output to a
+// temporary file and decodes the result.
+func processToJSON(t *testing.T, p *Processor, process func() error) *Output {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	p.config.Format = "json"
+	p.config.OutputPath = filepath.Join(t.TempDir(), "context.json")
+	p.config.FixedOutputPath = true
+	if err := process(); err != nil {
+		t.Fatalf("process failed: %v", err)
+	}
+	data, err := os.ReadFile(p.config.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := &Output{}
+	if err := json.Unmarshal(data, output); err != nil {
+		t.Fatalf("invalid output: %v", err)
+	}
+	return output
+}
+
+// newGitHubServer serves the given API paths as JSON, or as raw text for
+// string values, and rejects requests without the token.
+func newGitHubServer(t *testing.T, routes map[string]any) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+		route := r.URL.Path
+		if r.URL.Query().Has("page") || r.URL.Query().Has("ref") {
+			route += "?" + r.URL.RawQuery
+		}
+		body, ok := routes[route]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch body := body.(type) {
+		case int:
+			http.Error(w, http.StatusText(body), body)
+		case string:
+			fmt.Fprint(w, body)
+		default:
+			if route == "/repos/o/r/pulls/7/files" {
+				w.Header().Set("Link", fmt.Sprintf(`<http://%s/repos/o/r/pulls/7/files?page=2>; rel="next"`, r.Host))
+			}
+			json.NewEncoder(w).Encode(body)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProcessGitHubPullRequest(t *testing.T) {
+	user := func(login string) map[string]any { return map[string]any{"login": login} }
+	server := newGitHubServer(t, map[string]any{
+		"/repos/o/r/pulls/7": map[string]any{
+			"number": 7, "title": "Add feature", "body": "Adds it.\n", "state": "closed", "merged": true,
+			"html_url": "https://github.com/o/r/pull/7", "user": user("alice"), "created_at": "2024-03-01T10:00:00Z",
+			"head": map[string]any{"ref": "feature", "sha": "1111111aaaa"},
+			"base": map[string]any{"ref": "main", "sha": "2222222bbbb"},
+		},
+		"/repos/o/r/issues/7/comments": []map[string]any{
+			{"id": 10, "user": user("bob"), "body": "Looks useful", "created_at": "2024-03-01T12:00:00Z"},
+		},
+		"/repos/o/r/pulls/7/reviews": []map[string]any{
+			{"id": 20, "user": user("carol"), "body": "", "state": "APPROVED", "submitted_at": "2024-03-01T11:00:00Z"},
+			{"id": 21, "user": user("dave"), "body": "", "state": "COMMENTED", "submitted_at": "2024-03-01T11:30:00Z"},
+		},
+		"/repos/o/r/pulls/7/comments": []map[string]any{
+			{"id": 30, "user": user("carol"), "body": "Rename this", "path": "main.go", "line": 3, "diff_hunk": "@@ -1,2 +1,3 @@", "created_at": "2024-03-01T11:00:00Z"},
+			{"id": 31, "in_reply_to_id": 30, "user": user("alice"), "body": "Done", "path": "main.go", "line": 3, "created_at": "2024-03-01T11:10:00Z"},
+			{"id": 32, "user": user("carol"), "body": "Outdated", "path": "util.go", "original_line": 5, "diff_hunk": "@@ -5 +5 @@", "created_at": "2024-03-01T11:20:00Z"},
+		},
+		"/repos/o/r/pulls/7/commits": []map[string]any{
+			{"sha": "aaaaaaa1", "commit": map[string]any{"message": "First\n\nDetails", "author": map[string]any{"name": "Alice", "date": "2024-02-28T09:00:00Z"}}},
+			{"sha": "bbbbbbb2", "commit": map[string]any{"message": "Second", "author": map[string]any{"name": "Alice", "date": "2024-02-29T09:00:00Z"}}},
+		},
+		"/repos/o/r/pulls/7/files": []map[string]any{
+			{"filename": "main.go", "status": "modified", "patch": "@@ -1 +1 @@\n-a\n+b"},
+			{"filename": "old.go", "status": "removed", "patch": "@@ -1 +0,0 @@\n-x"},
+		},
+		"/repos/o/r/pulls/7/files?page=2": []map[string]any{
+			{"filename": "huge.go", "status": "added", "patch": ""},
+		},
+		"/repos/o/r/contents/main.go?ref=1111111aaaa": "package main\n",
+		"/repos/o/r/contents/huge.go?ref=1111111aaaa": http.StatusForbidden,
+	})
+	p := NewProcessor(ProcessorConfig{})
+	p.github = newGitHubClient(server.URL, "secret")
+	output := processToJSON(t, p, func() error { return p.ProcessGitHubDiscussion("https://github.com/o/r/pull/7") })
+
+	discussion := output.Discussion
+	if discussion == nil || discussion.Kind != "Pull Request" || discussion.State != "merged" || discussion.Author != "alice" || discussion.Body != "Adds it." {
+		t.Fatalf("unexpected discussion: %+v", discussion)
+	}
+	// The approval is kept and sorted before the later issue comment; the
+	// empty COMMENTED review is dropped.
+	if len(discussion.Comments) != 2 || discussion.Comments[0].State != "APPROVED" || discussion.Comments[1].Author != "bob" {
+		t.Errorf("unexpected comments: %+v", discussion.Comments)
+	}
+	if len(discussion.Reviews) != 2 {
+		t.Fatalf("got %d review threads, want 2: %+v", len(discussion.Reviews), discussion.Reviews)
+	}
+	if thread := discussion.Reviews[0]; thread.Path != "main.go" || thread.Line != 3 || len(thread.Comments) != 2 || thread.Comments[1].Body != "Done" {
+		t.Errorf("unexpected first thread: %+v", thread)
+	}
+	if thread := discussion.Reviews[1]; thread.Path != "util.go" || thread.Line != 5 || len(thread.Comments) != 1 {
+		t.Errorf("unexpected second thread: %+v", thread)
+	}
+
+	changes := output.Changes
+	if changes == nil || changes.Base != "main" || changes.HeadCommit != "1111111" {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+	if len(changes.Commits) != 2 || changes.Commits[0].Subject != "Second" || changes.Commits[1].Body != "Details" {
+		t.Errorf("commits not newest first: %+v", changes.Commits)
+	}
+	if len(changes.Files) != 3 || changes.Files[1].Status != "deleted" || changes.Files[2].Path != "huge.go" {
+		t.Errorf("unexpected file changes: %+v", changes.Files)
+	}
+	// old.go is deleted and huge.go cannot be fetched, so only main.go has
+	// its content.
+	if len(output.Files) != 1 || output.Files[0].Path != "main.go" || output.Files[0].Content != "package main\n" {
+		t.Errorf("unexpected files: %+v", output.Files)
+	}
+	if output.DirectoryTree != "main.go\n" {
+		t.Errorf("directory tree = %q", output.DirectoryTree)
+	}
+}
+
+func TestProcessGitHubIssue(t *testing.T) {
+	server := newGitHubServer(t, map[string]any{
+		"/repos/o/r/issues/3": map[string]any{
+			"number": 3, "title": "Crash on start", "body": "It crashes.", "state": "open",
+			"html_url": "https://github.com/o/r/issues/3", "user": map[string]any{"login": "erin"}, "created_at": "2024-01-02T08:00:00Z",
+		},
+		"/repos/o/r/issues/3/comments": []map[string]any{
+			{"id": 2, "user": map[string]any{"login": "frank"}, "body": "Second", "created_at": "2024-01-03T08:00:00Z"},
+			{"id": 1, "user": map[string]any{"login": "erin"}, "body": "First", "created_at": "2024-01-02T09:00:00Z"},
+		},
+	})
+	p := NewProcessor(ProcessorConfig{})
+	p.github = newGitHubClient(server.URL, "secret")
+	output := processToJSON(t, p, func() error { return p.ProcessGitHubDiscussion("https://github.com/o/r/issues/3") })
+
+	discussion := output.Discussion
+	if discussion == nil || discussion.Kind != "Issue" || discussion.Number != 3 || discussion.State != "open" || discussion.CreatedAt != "2024-01-02" {
+		t.Fatalf("unexpected discussion: %+v", discussion)
+	}
+	if len(discussion.Comments) != 2 || discussion.Comments[0].Body != "First" || discussion.Comments[1].Author != "frank" {
+		t.Errorf("unexpected comments: %+v", discussion.Comments)
+	}
+	if output.Changes != nil || len(output.Files) != 0 || len(discussion.Reviews) != 0 {
+		t.Errorf("issue has pull request sections: %+v", output)
+	}
+}
@@ -0,0 +1,134 @@
+package aicontext
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/rs/zerolog/log"
+)
+
+// wantsHistory reports whether git history is needed, so that clones must
+// not be shallow.
+func (c ProcessorConfig) wantsHistory() bool {
+	return c.GitMeta || c.GitHistory > 0
+}
+
+// addGitMeta annotates the files of output with the last commit that
+// touched them (--git-meta) and adds the most recent commits of the
+// repository (--git-history). It is a no-op outside git repositories and
+// for shallow clones, whose history would attribute every file to the
+// shallow boundary.
+func (p *Processor) addGitMeta(root string, output *Output) error {
+	if !p.config.wantsHistory() {
+		return nil
+	}
+	base, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(base); err == nil && !info.IsDir() {
+		base = filepath.Dir(base)
+	}
+	repo, err := git.PlainOpenWithOptions(base, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		log.Debug().Str("package", "aicontext").Str("path", root).Err(err).Msg("not a git repository, skipping git metadata")
+		return nil
+	}
+	if shallow, err := repo.Storer.Shallow(); err == nil && len(shallow) > 0 {
+		log.Debug().Str("package", "aicontext").Str("path", root).Msg("shallow repository, skipping git metadata")
+		return nil
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	commits, err := repo.Log(&git.LogOptions{From: head.Hash(), Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	defer commits.Close()
+
+	// Map repository paths, which use slashes, back to the files. Files
+	// that are not in HEAD, such as untracked ones, have no last commit and
+	// would otherwise keep the walk going through the whole history.
+	wanted := make(map[string]*FileEntry)
+	if p.config.GitMeta {
+		for i := range output.Files {
+			rel, err := filepath.Rel(worktree.Filesystem.Root(), filepath.Join(base, output.Files[i].Path))
+			if err != nil {
+				continue
+			}
+			rel = filepath.ToSlash(rel)
+			if _, err := headTree.FindEntry(rel); err == nil {
+				wanted[rel] = &output.Files[i]
+			}
+		}
+	}
+	err = commits.ForEach(func(c *object.Commit) error {
+		if len(output.History) < p.config.GitHistory {
+			output.History = append(output.History, commitInfo(c))
+		}
+		if len(wanted) > 0 {
+			if err := attributeChanges(c, wanted); err != nil {
+				return err
+			}
+		}
+		if len(wanted) == 0 && len(output.History) >= p.config.GitHistory {
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, storer.ErrStop) {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	return nil
+}
+
+// attributeChanges sets c as the last commit of the wanted files it changes
+// relative to its first parent and removes them from wanted.
+func attributeChanges(c *object.Commit, wanted map[string]*FileEntry) error {
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+	var parentTree *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return err
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return err
+		}
+	}
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if file, ok := wanted[change.To.Name]; ok {
+			info := commitInfo(c)
+			info.Body = ""
+			file.LastCommit = &info
+			delete(wanted, change.To.Name)
+		}
+	}
+	return nil
+}
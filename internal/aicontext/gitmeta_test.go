@@ -0,0 +1,37 @@
+package aicontext
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAddGitMeta(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit("Add files", "a.go", "package a\n", "pkg/b.go", "package pkg\n")
+	r.commit("Change b", "pkg/b.go", "package pkg // b\n")
+	for range 3 {
+		r.commit("Unrelated", "c.txt", r.clock.String())
+	}
+	r.write("untracked.go", "package untracked\n")
+
+	p := NewProcessor(ProcessorConfig{GitMeta: true, GitHistory: 2})
+	output := processToJSON(t, p, func() error { return p.ProcessDirectory(r.dir) })
+
+	want := map[string]string{"a.go": "Add files", "pkg/b.go": "Change b", "c.txt": "Unrelated"}
+	for _, file := range output.Files {
+		path := filepath.ToSlash(file.Path)
+		subject, tracked := want[path]
+		switch {
+		case !tracked && file.LastCommit != nil:
+			t.Errorf("%s is not in HEAD but has last commit %+v", path, file.LastCommit)
+		case tracked && (file.LastCommit == nil || file.LastCommit.Subject != subject):
+			t.Errorf("%s has last commit %+v, want %q", path, file.LastCommit, subject)
+		}
+	}
+	if len(output.Files) != 4 {
+		t.Errorf("got %d files, want 4", len(output.Files))
+	}
+	if len(output.History) != 2 || output.History[0].Subject != "Unrelated" {
+		t.Errorf("unexpected history: %+v", output.History)
+	}
+}
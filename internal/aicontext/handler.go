@@ -17,9 +17,23 @@ import (
 	"github.com/tanq16/ai-context/utils"
 )
 
-var URLRegex = map[string]string{
-	"gh":  "https://github.com/.+",
-	"dir": "^\\.?\\./.*|^/.*",
+// URLRegex routes cleaned URLs to source types, first match wins. A route
+// with a match function also needs it to accept the URL, so that http(s)
+// URLs of git remotes go to "git" and all others to "web".
+var URLRegex = []struct {
+	urlType string
+	regex   string
+	match   func(string) bool
+}{
+	{"archive", "^\\.?\\./.*|^/.*|^https?://.+", isArchive},
+	{"dir", "^\\.?\\./.*|^/.*", nil},
+	{"pr", "^https://github.com/[^/]+/[^/]+/pull/\\d+", nil},
+	{"issue", "^https://github.com/[^/]+/[^/]+/issues/\\d+", nil},
+	{"gh", "^https://github.com/.+", nil},
+	{"yt", "^https://www\\.youtube\\.com/watch\\?v=", nil},
+	{"pkg", "^(go|npm|pypi):.+", nil},
+	{"git", "^(https?|ssh|git)://.+|^[^/]+:.+", isGitRemote},
+	{"web", "^https?://.+", nil},
 }
 
 type result struct {
@@ -48,19 +62,31 @@ func cleanURL(rawURL string) (string, error) {
 	if match, _ := regexp.MatchString(`^\.?\.?\/.*`, rawURL); match {
 		return rawURL, nil
 	}
+	if packageSourceRegex.MatchString(rawURL) {
+		return rawURL, nil
+	}
+	if videoID, ok := youtubeVideoID(rawURL); ok {
+		return youtubeBaseURL + "/watch?v=" + videoID, nil
+	}
+	isGit := isGitRemote(rawURL)
+	if !isGit && !webURLRegex.MatchString(rawURL) {
+		return "", fmt.Errorf("only git repositories, web pages and local directories are supported")
+	}
+	if !strings.Contains(rawURL, "://") {
+		return rawURL, nil // scp-like ssh remote
+	}
 	parsedURL, err := url.Parse(rawURL)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse url: %w", err)
 	}
-	if !strings.Contains(parsedURL.Host, "github.com") {
-		return "", fmt.Errorf("only GitHub repositories and local directories are supported")
+	if isGit {
+		parsedURL.RawQuery = ""
 	}
-	parsedURL.RawQuery = ""
 	parsedURL.Fragment = ""
 	return parsedURL.String(), nil
 }
 
-func handlerWorker(ctx context.Context, toProcess input, resultChan chan result, includeGlobs []string, excludeGlobs []string, maxSize int64) {
+func handlerWorker(ctx context.Context, toProcess input, resultChan chan result, config ProcessorConfig, target *outputTarget) {
 	select {
 	case <-ctx.Done():
 		resultChan <- result{url: toProcess.url, err: ctx.Err()}
@@ -68,30 +94,64 @@ func handlerWorker(ctx context.Context, toProcess input, resultChan chan result,
 	default:
 	}
 
+	config.OutputPath, config.FixedOutputPath = target.pathFor(toProcess.urlType, toProcess.url)
 	switch toProcess.urlType {
-	case "gh":
-		output := path.Join("context", "gh-"+GetOutFileName(toProcess.url))
-		codeProcessor := NewProcessor(ProcessorConfig{
-			OutputPath:   output,
-			IncludeGlobs: includeGlobs,
-			ExcludeGlobs: excludeGlobs,
-			MaxSize:      maxSize,
-		})
+	case "gh", "git":
+		codeProcessor := NewProcessor(config)
 		utils.PrintIndentedRunning(fmt.Sprintf("%s: starting collection", toProcess.url))
-		err := codeProcessor.ProcessGitHubURL(toProcess.url)
+		err := codeProcessor.ProcessGitURL(toProcess.url)
+		if err != nil {
+			resultChan <- result{url: toProcess.url, err: err}
+			return
+		}
+		resultChan <- result{url: toProcess.url, err: nil}
+	case "pr", "issue":
+		codeProcessor := NewProcessor(config)
+		utils.PrintIndentedRunning(fmt.Sprintf("%s: fetching discussion", toProcess.url))
+		err := codeProcessor.ProcessGitHubDiscussion(toProcess.url)
+		if err != nil {
+			resultChan <- result{url: toProcess.url, err: err}
+			return
+		}
+		resultChan <- result{url: toProcess.url, err: nil}
+	case "yt":
+		codeProcessor := NewProcessor(config)
+		utils.PrintIndentedRunning(fmt.Sprintf("%s: fetching transcript", toProcess.url))
+		err := codeProcessor.ProcessYouTubeURL(toProcess.url)
+		if err != nil {
+			resultChan <- result{url: toProcess.url, err: err}
+			return
+		}
+		resultChan <- result{url: toProcess.url, err: nil}
+	case "web":
+		codeProcessor := NewProcessor(config)
+		utils.PrintIndentedRunning(fmt.Sprintf("%s: fetching page", toProcess.url))
+		err := codeProcessor.ProcessWebURL(toProcess.url)
+		if err != nil {
+			resultChan <- result{url: toProcess.url, err: err}
+			return
+		}
+		resultChan <- result{url: toProcess.url, err: nil}
+	case "archive":
+		codeProcessor := NewProcessor(config)
+		utils.PrintIndentedRunning(fmt.Sprintf("%s: extracting archive", toProcess.url))
+		err := codeProcessor.ProcessArchive(toProcess.url)
+		if err != nil {
+			resultChan <- result{url: toProcess.url, err: err}
+			return
+		}
+		resultChan <- result{url: toProcess.url, err: nil}
+	case "pkg":
+		codeProcessor := NewProcessor(config)
+		utils.PrintIndentedRunning(fmt.Sprintf("%s: downloading package", toProcess.url))
+		err := codeProcessor.ProcessPackage(toProcess.url)
 		if err != nil {
 			resultChan <- result{url: toProcess.url, err: err}
 			return
 		}
 		resultChan <- result{url: toProcess.url, err: nil}
 	case "dir":
-		output := path.Join("context", "dir-"+GetOutFileName(toProcess.url))
-		codeProcessor := NewProcessor(ProcessorConfig{
-			OutputPath:   output,
-			IncludeGlobs: includeGlobs,
-			ExcludeGlobs: excludeGlobs,
-			MaxSize:      maxSize,
-		})
+		codeProcessor := NewProcessor(config)
 		err := codeProcessor.ProcessDirectory(toProcess.url)
 		if err != nil {
 			resultChan <- result{url: toProcess.url, err: err}
@@ -101,26 +161,34 @@ func handlerWorker(ctx context.Context, toProcess input, resultChan chan result,
 	}
 }
 
-func Handler(ctx context.Context, urls []string, includeGlobs []string, excludeGlobs []string, maxSize int64, threads int, detailLog bool) {
+func Handler(ctx context.Context, urls []string, config ProcessorConfig, output string, threads int, detailLog bool) {
 	var cleanedUrls []string
 	for _, u := range urls {
 		cleaned, err := cleanURL(u)
 		if err != nil {
+			utils.PrintWarn(fmt.Sprintf("skipping %s: %v", u, err), err)
 			continue
 		}
 		cleanedUrls = append(cleanedUrls, cleaned)
 	}
 	urls = cleanedUrls
 
+	target, err := newOutputTarget(output, len(urls))
+	if err != nil {
+		utils.PrintFatal("invalid output", err)
+	}
+
 	utils.PrintRunning("Creating file structure")
 
-	if err := os.MkdirAll("context", 0755); err != nil {
-		utils.ClearLines(1)
-		utils.PrintFatal("couldn't create context directory", err)
-	}
-	if err := os.MkdirAll(path.Join("context", "images"), 0755); err != nil {
-		utils.ClearLines(1)
-		utils.PrintFatal("couldn't create images directory", err)
+	if target.dir != "" {
+		if err := os.MkdirAll(target.dir, 0755); err != nil {
+			utils.ClearLines(1)
+			utils.PrintFatal("couldn't create output directory", err)
+		}
+		if err := os.MkdirAll(path.Join(target.dir, "images"), 0755); err != nil {
+			utils.ClearLines(1)
+			utils.PrintFatal("couldn't create images directory", err)
+		}
 	}
 	utils.ClearLines(1)
 	totUrls := len(urls)
@@ -182,9 +250,9 @@ func Handler(ctx context.Context, urls []string, includeGlobs []string, excludeG
 	for _, u := range urls {
 		matched := false
 		var toProcess input
-		for ut, reg := range URLRegex {
-			if isMatch, _ := regexp.MatchString(reg, u); isMatch {
-				toProcess = input{url: u, urlType: ut}
+		for _, route := range URLRegex {
+			if isMatch, _ := regexp.MatchString(route.regex, u); isMatch && (route.match == nil || route.match(u)) {
+				toProcess = input{url: u, urlType: route.urlType}
 				matched = true
 				break
 			}
@@ -202,7 +270,7 @@ func Handler(ctx context.Context, urls []string, includeGlobs []string, excludeG
 			}
 
 			resultChan := make(chan result, 1)
-			handlerWorker(groupCtx, toProcess, resultChan, includeGlobs, excludeGlobs, maxSize)
+			handlerWorker(groupCtx, toProcess, resultChan, config, target)
 			
 			res := <-resultChan
 			if res.err != nil {
@@ -238,13 +306,15 @@ func Handler(ctx context.Context, urls []string, includeGlobs []string, excludeG
 		}
 	}
 
-	files, err := os.ReadDir(path.Join("context", "images"))
-	if err != nil {
-		errMsg += (fmt.Errorf("couldn't read images directory: %w", err)).Error() + "\n"
-	} else if len(files) == 0 {
-		err := os.RemoveAll(path.Join("context", "images"))
+	if target.dir != "" {
+		files, err := os.ReadDir(path.Join(target.dir, "images"))
 		if err != nil {
-			errMsg += (fmt.Errorf("failed to delete empty images directory: %w", err)).Error() + "\n"
+			errMsg += (fmt.Errorf("couldn't read images directory: %w", err)).Error() + "\n"
+		} else if len(files) == 0 {
+			err := os.RemoveAll(path.Join(target.dir, "images"))
+			if err != nil {
+				errMsg += (fmt.Errorf("failed to delete empty images directory: %w", err)).Error() + "\n"
+			}
 		}
 	}
 
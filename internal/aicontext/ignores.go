@@ -1,68 +1,317 @@
 package aicontext
 
 import (
+	"bufio"
+	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 )
 
 type PathFilter struct {
 	defaultExcludes []string
 	includePatterns []string
 	excludePatterns []string
+	useGitignore    bool
+	ignorePatterns  []gitignore.Pattern
+	loadedIgnores   map[string]bool
+	projectPatterns []gitignore.Pattern
+	includeRegex    []*regexp.Regexp
+	excludeRegex    []*regexp.Regexp
+	contentGrep     []*regexp.Regexp
+	contentExclude  []*regexp.Regexp
+	// ignoreMatcher and projectMatcher are built from the patterns on first
+	// use and dropped whenever patterns are added.
+	ignoreMatcher  gitignore.Matcher
+	projectMatcher gitignore.Matcher
+	// base is the processed directory's path inside the repository when
+	// only a sub-path is processed. Ignore patterns are matched against
+	// repository-relative paths.
+	base []string
+	// onlyPaths, when set, limits files to the listed relative paths and
+	// directories to their parents.
+	onlyPaths map[string]bool
+	onlyDirs  map[string]bool
+	// keepImages and keepDocs let image and document files past
+	// defaultExcludes for --images download and --extract-docs.
+	keepImages bool
+	keepDocs   bool
 }
 
-func newPathFilter(includePatterns []string, excludePatterns []string) *PathFilter {
+func newPathFilter(config ProcessorConfig) *PathFilter {
 	parsedIncludes := make([]string, 0)
-	for _, p := range includePatterns {
+	for _, p := range config.IncludeGlobs {
 		if p != "" {
 			parsedIncludes = append(parsedIncludes, p)
 		}
 	}
 
-	parsedExcludes := make([]string, len(excludePatterns))
-	for i, pattern := range excludePatterns {
-		parsedExcludes[i] = "*/" + pattern
+	parsedExcludes := make([]string, 0)
+	for _, p := range config.ExcludeGlobs {
+		if p != "" {
+			parsedExcludes = append(parsedExcludes, p)
+		}
 	}
 
 	return &PathFilter{
 		defaultExcludes: defaultIgnores,
 		includePatterns: parsedIncludes,
 		excludePatterns: parsedExcludes,
+		useGitignore:    !config.NoGitignore,
+		loadedIgnores:   make(map[string]bool),
+		includeRegex:    config.IncludeRegex,
+		excludeRegex:    config.ExcludeRegex,
+		contentGrep:     config.Grep,
+		contentExclude:  config.ExcludeGrep,
+		keepImages:      config.Images == ImagesDownload,
+		keepDocs:        config.ExtractDocs,
 	}
 }
 
+// loadRepoIgnores loads the user's global excludes file and the
+// .git/info/exclude of root. Both have lower priority than any .gitignore.
+func (pf *PathFilter) loadRepoIgnores(root string) {
+	if !pf.useGitignore {
+		return
+	}
+	if global, err := gitignore.LoadGlobalPatterns(osfs.New("/")); err == nil {
+		pf.ignorePatterns = append(pf.ignorePatterns, global...)
+	}
+	pf.ignorePatterns = append(pf.ignorePatterns, readIgnoreFile(filepath.Join(root, ".git", "info", "exclude"), nil)...)
+	pf.ignoreMatcher = nil
+}
+
+// loadParentIgnores prepares the filter for processing root, a directory
+// below the repository root configRoot: it sets base and reads the
+// .gitignore files from configRoot down to root's parent, which the walk of
+// root never visits.
+func (pf *PathFilter) loadParentIgnores(configRoot string, root string) {
+	dir := root
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		dir = filepath.Dir(root)
+	}
+	rel, err := filepath.Rel(configRoot, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return
+	}
+	pf.base = splitPath(rel)
+	if !pf.useGitignore {
+		return
+	}
+	for i := range pf.base {
+		domain := pf.base[:i:i]
+		pf.ignorePatterns = append(pf.ignorePatterns, readIgnoreFile(filepath.Join(configRoot, filepath.Join(domain...), ".gitignore"), domain)...)
+	}
+	pf.ignoreMatcher = nil
+}
+
+// loadGitignore reads the .gitignore in relDir (relative to root) once.
+// Directories must be loaded parent-first so deeper files take priority.
+func (pf *PathFilter) loadGitignore(root string, relDir string) {
+	if !pf.useGitignore || pf.loadedIgnores[relDir] {
+		return
+	}
+	pf.loadedIgnores[relDir] = true
+	if patterns := readIgnoreFile(filepath.Join(root, relDir, ".gitignore"), pf.repoPath(relDir)); len(patterns) > 0 {
+		pf.ignorePatterns = append(pf.ignorePatterns, patterns...)
+		pf.ignoreMatcher = nil
+	}
+}
+
+// loadProjectIgnores reads a .aicontextignore file. Its patterns are matched
+// on their own, so a .gitignore negation cannot re-include what it excludes,
+// and they apply even when gitignore handling is disabled.
+func (pf *PathFilter) loadProjectIgnores(path string) {
+	pf.projectPatterns = append(pf.projectPatterns, readIgnoreFile(path, nil)...)
+	pf.projectMatcher = nil
+}
+
+func readIgnoreFile(path string, domain []string) []gitignore.Pattern {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var patterns []gitignore.Pattern
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		patterns = append(patterns, gitignore.ParsePattern(line, domain))
+	}
+	return patterns
+}
+
+// repoPath returns the segments of a path relative to the processed root
+// as a path relative to the repository.
+func (pf *PathFilter) repoPath(relPath string) []string {
+	return slices.Concat(pf.base, splitPath(relPath))
+}
+
+func splitPath(relPath string) []string {
+	if relPath == "." || relPath == "" {
+		return nil
+	}
+	return strings.Split(filepath.ToSlash(relPath), "/")
+}
+
+// restrictTo limits the filter to the given relative file paths.
+func (pf *PathFilter) restrictTo(paths map[string]bool) {
+	pf.onlyPaths = paths
+	pf.onlyDirs = make(map[string]bool)
+	for p := range paths {
+		for dir := filepath.Dir(p); dir != "."; dir = filepath.Dir(dir) {
+			pf.onlyDirs[dir] = true
+		}
+	}
+}
+
+// shouldIncludePath checks a slash-separated file path that is not walked,
+// such as a file of a pull request, together with its parent directories.
+func (pf *PathFilter) shouldIncludePath(filePath string) bool {
+	segments := strings.Split(filePath, "/")
+	for i := 1; i < len(segments); i++ {
+		if !pf.shouldInclude(filepath.Join(segments[:i]...), true) {
+			return false
+		}
+	}
+	return pf.shouldInclude(filepath.FromSlash(filePath), false)
+}
+
+// keepsDefaultExcluded reports whether a file matching defaultExcludes is
+// still wanted as an image or document to extract.
+func (pf *PathFilter) keepsDefaultExcluded(path string, isDir bool) bool {
+	return !isDir && (pf.keepImages && isImageFile(path) || pf.keepDocs && isDocumentFile(path))
+}
+
 func (pf *PathFilter) shouldInclude(path string, isDir bool) bool {
 	for _, pattern := range pf.defaultExcludes {
-		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched && !pf.keepsDefaultExcluded(path, isDir) {
+			return false
+		}
+	}
+	if pf.onlyPaths != nil && path != "." {
+		if isDir && !pf.onlyDirs[path] || !isDir && !pf.onlyPaths[path] {
+			return false
+		}
+	}
+
+	if len(pf.ignorePatterns) > 0 && path != "." {
+		if pf.ignoreMatcher == nil {
+			pf.ignoreMatcher = gitignore.NewMatcher(pf.ignorePatterns)
+		}
+		if pf.ignoreMatcher.Match(pf.repoPath(path), isDir) {
+			return false
+		}
+	}
+	if len(pf.projectPatterns) > 0 && path != "." {
+		if pf.projectMatcher == nil {
+			pf.projectMatcher = gitignore.NewMatcher(pf.projectPatterns)
+		}
+		if pf.projectMatcher.Match(pf.repoPath(path), isDir) {
 			return false
 		}
 	}
 
 	for _, pattern := range pf.excludePatterns {
-		if matched, _ := filepath.Match(pattern, path); matched {
+		if matchGlob(pattern, path) {
 			return false
 		}
-		if matched, _ := filepath.Match(strings.TrimPrefix(pattern, "*/"), filepath.Base(path)); matched {
+	}
+
+	// Regexes see the slash-separated relative path; directories get a
+	// trailing slash so that "^gen/" prunes the whole subtree.
+	regexPath := filepath.ToSlash(path)
+	if isDir {
+		regexPath += "/"
+	}
+	for _, re := range pf.excludeRegex {
+		if re.MatchString(regexPath) {
 			return false
 		}
 	}
 
-	if len(pf.includePatterns) > 0 {
-		if isDir {
-			return true
+	if isDir {
+		return true
+	}
+	if len(pf.includePatterns) > 0 && !slices.ContainsFunc(pf.includePatterns, func(pattern string) bool {
+		return matchGlob(pattern, path)
+	}) {
+		return false
+	}
+	if len(pf.includeRegex) > 0 && !slices.ContainsFunc(pf.includeRegex, func(re *regexp.Regexp) bool {
+		return re.MatchString(regexPath)
+	}) {
+		return false
+	}
+	return true
+}
+
+// shouldIncludeContent applies --grep and --exclude-grep to a file that
+// already passed shouldInclude.
+func (pf *PathFilter) shouldIncludeContent(content []byte) bool {
+	for _, re := range pf.contentExclude {
+		if re.Match(content) {
+			return false
 		}
-		for _, pattern := range pf.includePatterns {
-			if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
-				return true
+	}
+	if len(pf.contentGrep) > 0 {
+		return slices.ContainsFunc(pf.contentGrep, func(re *regexp.Regexp) bool {
+			return re.Match(content)
+		})
+	}
+	return true
+}
+
+// matchGlob reports whether relPath matches an include or exclude pattern.
+// A pattern without a slash matches the base name at any depth ("*.md",
+// "testdata"). A pattern with a slash is anchored at the processed root and
+// matched against the whole relative path, where "**" spans zero or more
+// directories ("docs/**/*.md", "internal/**/testdata/*"); a leading slash is
+// optional.
+func matchGlob(pattern string, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(relPath))
+		return matched
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(relPath, "/"))
+}
+
+func matchSegments(pattern []string, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for len(pattern) > 1 && pattern[1] == "**" {
+				pattern = pattern[1:]
 			}
-			if matched, _ := filepath.Match(pattern, path); matched {
+			if len(pattern) == 1 {
 				return true
 			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
 		}
-		return false
+		if len(parts) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], parts[0]); !matched {
+			return false
+		}
+		pattern = pattern[1:]
+		parts = parts[1:]
 	}
-
-	return true
+	return len(parts) == 0
 }
 
 func isBinary(content []byte) bool {
@@ -84,6 +333,8 @@ var defaultIgnores = []string{
 	".gitignore",
 	".gitmodules",
 	".gitattributes",
+	".aicontextignore",
+	".aicontext.yaml",
 	"node_modules",
 	"*.gz",
 	"*.bz2",
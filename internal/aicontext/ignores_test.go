@@ -0,0 +1,146 @@
+package aicontext
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTree creates files, given as slash-separated paths and contents,
+// below dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(target, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func outputPaths(output *Output) []string {
+	var paths []string
+	for _, file := range output.Files {
+		paths = append(paths, filepath.ToSlash(file.Path))
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func TestGitignore(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		subPath string
+		want    []string
+	}{
+		{
+			name: "negation",
+			files: map[string]string{
+				".gitignore": "*.log\n!keep.log\n",
+				"a.log":      "a", "keep.log": "keep", "sub/b.log": "b", "sub/keep.log": "keep", "main.go": "package main",
+			},
+			want: []string{"keep.log", "main.go", "sub/keep.log"},
+		},
+		{
+			name: "directory only",
+			files: map[string]string{
+				".gitignore":   "build/\n",
+				"build/out.js": "out", "sub/build/out.js": "out", "tools/build": "#!/bin/sh", "main.go": "package main",
+			},
+			want: []string{"main.go", "tools/build"},
+		},
+		{
+			name: "anchored",
+			files: map[string]string{
+				".gitignore":         "/third_party\n",
+				"third_party/lib.go": "package lib", "pkg/third_party/lib.go": "package lib", "main.go": "package main",
+			},
+			want: []string{"main.go", "pkg/third_party/lib.go"},
+		},
+		{
+			name: "nested overrides parent",
+			files: map[string]string{
+				".gitignore":        "*.tmp\ndocs/\n",
+				"a.tmp":             "a",
+				"sub/.gitignore":    "!important.tmp\nlocal.go\n",
+				"sub/important.tmp": "keep", "sub/other.tmp": "drop", "sub/local.go": "package sub", "local.go": "package main",
+				"docs/guide.md": "# Guide",
+			},
+			want: []string{"local.go", "sub/important.tmp"},
+		},
+		{
+			name: "sub-path inherits parent ignores",
+			files: map[string]string{
+				".gitignore":     "*.log\n/pkg/gen/\nsecret.go\n",
+				"pkg/.gitignore": "*.bak\n",
+				"pkg/a.go":       "package pkg", "pkg/a.log": "log", "pkg/a.bak": "bak", "pkg/gen/gen.go": "package gen",
+				"pkg/sub/secret.go": "package sub", "pkg/sub/b.go": "package sub",
+			},
+			subPath: "pkg",
+			want:    []string{"a.go", "sub/b.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, tt.files)
+			p := NewProcessor(ProcessorConfig{})
+			output := processToJSON(t, p, func() error {
+				return p.processPath(dir, filepath.Join(dir, filepath.FromSlash(tt.subPath)))
+			})
+			if got := outputPaths(output); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		// Without a slash the pattern matches the base name at any depth.
+		{"*.md", "README.md", true},
+		{"*.md", "docs/guide/intro.md", true},
+		{"testdata", "pkg/testdata", true},
+		{"*.md", "docs.md/file.go", false},
+		// With a slash it is anchored at the root.
+		{"docs/*.md", "docs/a.md", true},
+		{"docs/*.md", "sub/docs/a.md", false},
+		{"docs/*.md", "docs/sub/a.md", false},
+		{"/docs/*.md", "docs/a.md", true},
+		// A leading ** spans zero or more directories.
+		{"**/x", "x", true},
+		{"**/x", "a/x", true},
+		{"**/x", "a/b/x", true},
+		{"**/x", "a/xy", false},
+		// A trailing ** matches everything below and, spanning zero
+		// segments, the directory itself, so excluding it prunes the walk.
+		{"a/**", "a/b", true},
+		{"a/**", "a/b/c", true},
+		{"a/**", "a", true},
+		{"a/**", "b/a/c", false},
+		// ** in the middle spans zero or more directories.
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "a/x/y/c", false},
+		{"a/**/b", "x/a/b", false},
+		{"a/**/**/b", "a/b", true},
+		{"docs/**/*.md", "docs/a/b/c.md", true},
+		{"internal/**/testdata/*", "internal/x/testdata/f.json", true},
+		{"internal/**/testdata/*", "internal/testdata/sub/f.json", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, filepath.FromSlash(tt.path)); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
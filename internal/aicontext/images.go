@@ -0,0 +1,282 @@
+package aicontext
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Values of --images.
+const (
+	ImagesNone     = "none"
+	ImagesLink     = "link"
+	ImagesDownload = "download"
+)
+
+var ImageModes = []string{ImagesNone, ImagesLink, ImagesDownload}
+
+func ValidateImages(mode string) error {
+	if !slices.Contains(ImageModes, mode) && mode != "" {
+		return fmt.Errorf("unknown images mode %q (expected one of %s)", mode, strings.Join(ImageModes, ", "))
+	}
+	return nil
+}
+
+// maxImageBytes is the largest image downloaded when --max-size is not set.
+const maxImageBytes = 20 << 20
+
+// imageExtensions are the images that --images download copies out of
+// directories although defaultIgnores excludes them.
+var imageExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true,
+	".svg": true, ".bmp": true, ".tif": true, ".tiff": true,
+}
+
+var imageContentTypes = map[string]string{
+	"image/png": ".png", "image/jpeg": ".jpg", "image/gif": ".gif", "image/webp": ".webp",
+	"image/svg+xml": ".svg", "image/bmp": ".bmp", "image/tiff": ".tiff",
+}
+
+// ImageEntry is an image copied next to the output, with Link relative to
+// the output file.
+type ImageEntry struct {
+	Path string `json:"path"`
+	Link string `json:"link"`
+	Size int64  `json:"size"`
+}
+
+// imageStore saves the images of one source under images/<source>/ next to
+// its output file, named by content hash so that repeated images are
+// stored once.
+type imageStore struct {
+	dir      string
+	linkBase string
+	byHash   map[string]string
+	byURL    map[string]string
+}
+
+func isImageFile(filePath string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(filePath))]
+}
+
+// images returns the image store of the processor, creating it on first
+// use.
+func (p *Processor) images() (*imageStore, error) {
+	if p.imageStore != nil {
+		return p.imageStore, nil
+	}
+	if p.config.OutputPath == "" || p.config.OutputPath == StdoutOutput {
+		return nil, fmt.Errorf("--images download needs an output file or directory")
+	}
+	outputDir := filepath.Dir(p.config.OutputPath)
+	name := strings.TrimSuffix(filepath.Base(p.config.OutputPath), filepath.Ext(p.config.OutputPath))
+	p.imageStore = &imageStore{
+		dir:      filepath.Join(outputDir, "images", name),
+		linkBase: outputDir,
+		byHash:   make(map[string]string),
+		byURL:    make(map[string]string),
+	}
+	return p.imageStore, nil
+}
+
+// save writes data unless an image with the same content was saved before
+// and returns its link relative to the output file.
+func (s *imageStore) save(data []byte, ext string) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:8])
+	if link, ok := s.byHash[hash]; ok {
+		return link, nil
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create images directory: %w", err)
+	}
+	file := filepath.Join(s.dir, hash+ext)
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write image: %w", err)
+	}
+	link, err := filepath.Rel(s.linkBase, file)
+	if err != nil {
+		return "", err
+	}
+	s.byHash[hash] = filepath.ToSlash(link)
+	return s.byHash[hash], nil
+}
+
+// imageExtension picks the file extension of an image from its name, its
+// content type or, failing both, its content.
+func imageExtension(name string, contentType string, data []byte) string {
+	if ext := strings.ToLower(path.Ext(name)); imageExtensions[ext] {
+		return ext
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if ext, ok := imageContentTypes[mediaType]; ok {
+		return ext
+	}
+	if ext, ok := imageContentTypes[http.DetectContentType(data)]; ok {
+		return ext
+	}
+	return ""
+}
+
+// copyImage saves an image file of a directory source and records it in
+// output.
+func (p *Processor) copyImage(relPath string, content []byte, output *Output) error {
+	store, err := p.images()
+	if err != nil {
+		return err
+	}
+	link, err := store.save(content, imageExtension(relPath, "", content))
+	if err != nil {
+		return err
+	}
+	output.Images = append(output.Images, ImageEntry{Path: filepath.ToSlash(relPath), Link: link, Size: int64(len(content))})
+	return nil
+}
+
+// rewriteImages applies --images to the <img> elements of a page before it
+// is converted: they are removed, pointed at their absolute URL, or
+// downloaded and pointed at the local copy. Images that fail to download
+// keep their absolute URL.
+func (p *Processor) rewriteImages(client *http.Client, content *html.Node, base *url.URL) {
+	var images []*html.Node
+	walkHTML(content, func(n *html.Node) bool {
+		if n.DataAtom == atom.Img {
+			images = append(images, n)
+		}
+		return true
+	})
+	for _, img := range images {
+		src := htmlAttr(img, "src")
+		if src == "" || strings.HasPrefix(src, "data:") && htmlAttr(img, "data-src") != "" {
+			src = htmlAttr(img, "data-src") // lazy loading
+		}
+		link := ""
+		switch {
+		case p.config.Images != ImagesLink && p.config.Images != ImagesDownload:
+		case strings.HasPrefix(src, "data:"):
+			if p.config.Images == ImagesDownload {
+				link = p.saveDataImage(src)
+			}
+		default:
+			resolved, err := base.Parse(src)
+			if err != nil || src == "" {
+				break
+			}
+			link = resolved.String()
+			if p.config.Images == ImagesDownload {
+				if saved, err := p.downloadImage(client, resolved); err == nil {
+					link = saved
+				} else {
+					log.Debug().Str("package", "aicontext").Str("url", link).Err(err).Msg("failed to download image")
+				}
+			}
+		}
+		if link == "" {
+			img.Parent.RemoveChild(img)
+			continue
+		}
+		setHTMLAttr(img, "src", link)
+	}
+}
+
+func (p *Processor) downloadImage(client *http.Client, imageURL *url.URL) (string, error) {
+	store, err := p.images()
+	if err != nil {
+		return "", err
+	}
+	if link, ok := store.byURL[imageURL.String()]; ok {
+		return link, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, imageURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	userAgent := p.config.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+	limit := int64(maxImageBytes)
+	if p.config.MaxSize > 0 {
+		limit = p.config.MaxSize
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > limit {
+		return "", fmt.Errorf("image larger than %d bytes", limit)
+	}
+	ext := imageExtension(imageURL.Path, resp.Header.Get("Content-Type"), data)
+	if ext == "" {
+		return "", fmt.Errorf("not an image")
+	}
+	link, err := store.save(data, ext)
+	if err != nil {
+		return "", err
+	}
+	store.byURL[imageURL.String()] = link
+	return link, nil
+}
+
+// saveDataImage saves an image inlined as a base64 data URL.
+func (p *Processor) saveDataImage(src string) string {
+	meta, payload, ok := strings.Cut(strings.TrimPrefix(src, "data:"), ",")
+	mediaType, isBase64 := strings.CutSuffix(meta, ";base64")
+	if !ok || !isBase64 {
+		return ""
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return ""
+	}
+	return p.saveImage("", mediaType, data)
+}
+
+// saveImage saves an embedded image with --images download and returns its
+// link, or "" when it is not saved.
+func (p *Processor) saveImage(name string, contentType string, data []byte) string {
+	ext := imageExtension(name, contentType, data)
+	store, err := p.images()
+	if ext == "" || err != nil {
+		return ""
+	}
+	link, err := store.save(data, ext)
+	if err != nil {
+		log.Debug().Str("package", "aicontext").Str("image", name).Err(err).Msg("failed to save image")
+		return ""
+	}
+	return link
+}
+
+func setHTMLAttr(n *html.Node, key string, value string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
@@ -0,0 +1,177 @@
+package aicontext
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// contentTransformer rewrites the content of a source file.
+type contentTransformer func(content []byte) (string, error)
+
+// outlineTransformers reduce files to their API shape with --outline, by
+// the language detectLanguage assigns them.
+var outlineTransformers = map[string]contentTransformer{
+	"go": outlineGo,
+}
+
+// transformContent applies the transformer of the file's language, if any
+// applies, and marks the entry. Files that fail to transform, e.g. Go files
+// with syntax errors, are kept in full.
+func (p *Processor) transformContent(entry *FileEntry) {
+	transform, ok := outlineTransformers[entry.Language]
+	if !p.config.Outline || !ok {
+		return
+	}
+	text, err := transform([]byte(entry.Content))
+	if err != nil {
+		log.Debug().Str("package", "aicontext").Str("path", entry.Path).Err(err).Msg("failed to outline file")
+		return
+	}
+	entry.Content = text
+	entry.Size = int64(len(text))
+	entry.Outline = true
+}
+
+// outlineGo keeps the package clause and documentation, the imports, all
+// type declarations, exported constants and variables, and the signatures
+// and doc comments of exported functions and methods.
+func outlineGo(content []byte) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", content, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse go file: %w", err)
+	}
+	var sb strings.Builder
+	if file.Doc != nil {
+		for _, comment := range file.Doc.List {
+			sb.WriteString(comment.Text + "\n")
+		}
+	}
+	fmt.Fprintf(&sb, "package %s\n", file.Name.Name)
+	for _, decl := range file.Decls {
+		var node ast.Node
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if !decl.Name.IsExported() {
+				continue
+			}
+			decl.Body = nil
+			node = decl
+		case *ast.GenDecl:
+			if decl.Tok == token.CONST || decl.Tok == token.VAR {
+				decl.Specs = exportedValueSpecs(decl.Specs)
+				if len(decl.Specs) == 0 {
+					continue
+				}
+				emptyFuncLits(decl)
+			}
+			node = decl
+		default:
+			continue
+		}
+		sb.WriteString("\n")
+		if err := printGoNode(&sb, fset, file, node); err != nil {
+			return "", err
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// exportedValueSpecs keeps the const and var specs declaring an exported
+// name. Specs without values repeat the previous expression, as in iota
+// enumerations, so such groups are kept whole if they export anything.
+func exportedValueSpecs(specs []ast.Spec) []ast.Spec {
+	var kept []ast.Spec
+	implicit := false
+	for _, spec := range specs {
+		value := spec.(*ast.ValueSpec)
+		implicit = implicit || len(value.Values) == 0 && value.Type == nil
+		for _, name := range value.Names {
+			if name.IsExported() {
+				kept = append(kept, spec)
+				break
+			}
+		}
+	}
+	if implicit && len(kept) > 0 {
+		return specs
+	}
+	return kept
+}
+
+// emptyFuncLits drops the bodies of function literals in variable
+// initializers.
+func emptyFuncLits(node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.FuncLit); ok {
+			lit.Body = &ast.BlockStmt{Lbrace: lit.Type.End(), Rbrace: lit.Type.End()}
+			return false
+		}
+		return true
+	})
+}
+
+// printGoNode prints node with the comments of file that lie within it,
+// including its doc comment, so comments in removed bodies are dropped.
+func printGoNode(sb *strings.Builder, fset *token.FileSet, file *ast.File, node ast.Node) error {
+	start, end := node.Pos(), node.End()
+	switch node := node.(type) {
+	case *ast.FuncDecl:
+		if node.Doc != nil {
+			start = node.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if node.Doc != nil {
+			start = node.Doc.Pos()
+		}
+	}
+	var comments []*ast.CommentGroup
+	for _, group := range file.Comments {
+		if group.Pos() >= start && group.End() <= end && !removedComment(node, group) {
+			comments = append(comments, group)
+		}
+	}
+	var buf bytes.Buffer
+	config := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := config.Fprint(&buf, fset, &printer.CommentedNode{Node: node, Comments: comments}); err != nil {
+		return fmt.Errorf("failed to print go outline: %w", err)
+	}
+	sb.Write(buf.Bytes())
+	return nil
+}
+
+// removedComment reports whether group lies outside the specs kept in a
+// const or var declaration. Function literal bodies were emptied before, so
+// their comments are outside too.
+func removedComment(node ast.Node, group *ast.CommentGroup) bool {
+	decl, ok := node.(*ast.GenDecl)
+	if !ok || decl.Tok != token.CONST && decl.Tok != token.VAR {
+		return false
+	}
+	if decl.Doc == group {
+		return false
+	}
+	for _, spec := range decl.Specs {
+		value := spec.(*ast.ValueSpec)
+		start := value.Pos()
+		if value.Doc != nil {
+			start = value.Doc.Pos()
+		}
+		end := value.End()
+		if value.Comment != nil {
+			end = value.Comment.End()
+		}
+		if group.Pos() >= start && group.End() <= end {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package aicontext
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files")
+
+func TestOutlineGo(t *testing.T) {
+	dir := filepath.Join("testdata", "outline")
+	input, err := os.ReadFile(filepath.Join(dir, "input.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := outlineGo(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join(dir, "output.golden")
+	if *updateGolden {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("outline differs from %s:\n%s", golden, got)
+	}
+
+	if _, err := outlineGo([]byte("package broken\nfunc {")); err == nil {
+		t.Error("outlined a file with syntax errors")
+	}
+}
@@ -0,0 +1,78 @@
+package aicontext
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// StdoutOutput is the --output value that writes context to stdout.
+const StdoutOutput = "-"
+
+// stdoutMu keeps concurrent workers from interleaving their outputs.
+var stdoutMu sync.Mutex
+
+// outputTarget resolves the --output flag into a path for every source. The
+// flag accepts "-" for stdout, a directory, a naming template containing
+// {type} and/or {name}, or (for a single source) a file path.
+type outputTarget struct {
+	spec     string
+	dir      string
+	template bool
+}
+
+func newOutputTarget(spec string, sources int) (*outputTarget, error) {
+	switch {
+	case spec == "":
+		return &outputTarget{dir: "context"}, nil
+	case spec == StdoutOutput:
+		return &outputTarget{spec: spec}, nil
+	case strings.Contains(spec, "{type}") || strings.Contains(spec, "{name}"):
+		target := &outputTarget{spec: spec, template: true}
+		if dir := filepath.Dir(spec); !strings.Contains(dir, "{") {
+			target.dir = dir
+		}
+		return target, nil
+	}
+	if info, err := os.Stat(spec); (err == nil && info.IsDir()) || strings.HasSuffix(spec, "/") || strings.HasSuffix(spec, string(filepath.Separator)) {
+		return &outputTarget{dir: filepath.Clean(spec)}, nil
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("output %q is a file but %d sources were given; use a directory or a naming template like {type}-{name}.md", spec, sources)
+	}
+	return &outputTarget{spec: spec, dir: filepath.Dir(spec)}, nil
+}
+
+// pathFor returns the output path for a source and whether it is fixed, i.e.
+// chosen by the user and not to be given the extension of the output format.
+func (t *outputTarget) pathFor(urlType string, url string) (string, bool) {
+	name := strings.TrimSuffix(GetOutFileName(url), ".md")
+	switch {
+	case t.spec == StdoutOutput:
+		return StdoutOutput, true
+	case t.template:
+		return strings.NewReplacer("{type}", urlType, "{name}", name).Replace(t.spec), true
+	case t.spec != "":
+		return t.spec, true
+	default:
+		return filepath.Join(t.dir, urlType+"-"+name+".md"), false
+	}
+}
+
+// writeStdout renders output into memory first so that it reaches stdout in
+// one piece.
+func (p *Processor) writeStdout(output *Output) error {
+	var buf bytes.Buffer
+	if err := p.renderer().Render(&buf, output); err != nil {
+		return err
+	}
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write to stdout: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package aicontext
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	DefaultGoProxy     = "https://proxy.golang.org"
+	DefaultNPMRegistry = "https://registry.npmjs.org"
+	DefaultPyPIURL     = "https://pypi.org"
+)
+
+// packageSourceRegex matches package sources such as
+// go:github.com/spf13/cobra@v1.8.0, npm:lodash@4.17.21 and
+// pypi:requests==2.31.0.
+var packageSourceRegex = regexp.MustCompile(`^(go|npm|pypi):(.+)$`)
+
+// packageSource is a parsed package source; Version is empty when the
+// source does not name one.
+type packageSource struct {
+	Ecosystem string
+	Name      string
+	Version   string
+}
+
+func parsePackageSource(source string) (packageSource, error) {
+	match := packageSourceRegex.FindStringSubmatch(source)
+	if match == nil {
+		return packageSource{}, fmt.Errorf("not a package source: %s", source)
+	}
+	pkg := packageSource{Ecosystem: match[1], Name: match[2]}
+	switch pkg.Ecosystem {
+	case "pypi":
+		pkg.Name, pkg.Version, _ = strings.Cut(pkg.Name, "==")
+	default:
+		// Scoped npm packages start with "@", so only a later "@"
+		// separates the version.
+		if i := strings.LastIndex(pkg.Name, "@"); i > 0 {
+			pkg.Name, pkg.Version = pkg.Name[:i], pkg.Name[i+1:]
+		}
+	}
+	if pkg.Name == "" || strings.ContainsAny(pkg.Name, " ?#") {
+		return packageSource{}, fmt.Errorf("invalid package name in %s", source)
+	}
+	return pkg, nil
+}
+
+// ProcessPackage downloads the source archive of a Go module, npm package
+// or PyPI project from its proxy or registry and processes it like a local
+// directory. Sources without a version get the latest one, or for Go
+// modules the one required by --go-mod when given.
+func (p *Processor) ProcessPackage(source string) error {
+	pkg, err := parsePackageSource(source)
+	if err != nil {
+		return err
+	}
+	switch pkg.Ecosystem {
+	case "go":
+		return p.processGoModule(pkg.Name, pkg.Version)
+	case "npm":
+		return p.processNPMPackage(pkg.Name, pkg.Version)
+	default:
+		return p.processPyPIPackage(pkg.Name, pkg.Version)
+	}
+}
+
+// goProxy returns the configured proxy, else the first proxy of GOPROXY,
+// else DefaultGoProxy.
+func (p *Processor) goProxy() string {
+	if p.config.GoProxyURL != "" {
+		return strings.TrimSuffix(p.config.GoProxyURL, "/")
+	}
+	for _, entry := range strings.FieldsFunc(os.Getenv("GOPROXY"), func(r rune) bool { return r == ',' || r == '|' }) {
+		if webURLRegex.MatchString(entry) {
+			return strings.TrimSuffix(entry, "/")
+		}
+	}
+	return DefaultGoProxy
+}
+
+func (p *Processor) processGoModule(modulePath string, version string) error {
+	if version == "" && p.config.GoModFile != "" {
+		resolvedPath, resolvedVersion, localDir, err := goModRequirement(p.config.GoModFile, modulePath)
+		if err != nil {
+			return err
+		}
+		if localDir != "" {
+			log.Debug().Str("package", "aicontext").Str("module", modulePath).Str("dir", localDir).Msg("module replaced by local directory")
+			return p.ProcessDirectory(localDir)
+		}
+		modulePath, version = resolvedPath, resolvedVersion
+	}
+	escapedPath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return fmt.Errorf("invalid module path: %w", err)
+	}
+	proxy := p.goProxy()
+	if version == "" || version == "latest" {
+		if version, err = p.latestGoVersion(proxy, escapedPath); err != nil {
+			return fmt.Errorf("failed to resolve latest version of %s: %w", modulePath, err)
+		}
+	}
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return fmt.Errorf("invalid module version: %w", err)
+	}
+	// Module zips hold every file below <module>@<version>/.
+	return p.processPackageArchive(proxy+"/"+escapedPath+"/@v/"+escapedVersion+".zip", modulePath+"@"+version)
+}
+
+// latestGoVersion picks the highest release, else pre-release, tagged in
+// the proxy's version list and falls back to @latest for modules without
+// tags, like cmd/go does.
+func (p *Processor) latestGoVersion(proxy string, escapedPath string) (string, error) {
+	list, err := p.fetchText(proxy + "/" + escapedPath + "/@v/list")
+	if err != nil {
+		return "", err
+	}
+	latest, latestPrerelease := "", ""
+	for _, version := range strings.Fields(list) {
+		switch {
+		case !semver.IsValid(version):
+		case semver.Prerelease(version) == "":
+			if semver.Compare(version, latest) > 0 {
+				latest = version
+			}
+		case semver.Compare(version, latestPrerelease) > 0:
+			latestPrerelease = version
+		}
+	}
+	if latest == "" {
+		latest = latestPrerelease
+	}
+	if latest != "" {
+		return latest, nil
+	}
+	var info struct{ Version string }
+	if err := p.fetchJSON(proxy+"/"+escapedPath+"/@latest", "", &info); err != nil {
+		return "", err
+	}
+	return info.Version, nil
+}
+
+func readGoMod(goModPath string) (*modfile.File, error) {
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read go.mod: %w", err)
+	}
+	file, err := modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse go.mod: %w", err)
+	}
+	return file, nil
+}
+
+// goModRequirement looks up the version of modulePath required by a go.mod
+// file, following its replace directives. A replacement by a local
+// directory is returned as localDir, relative to the go.mod file.
+func goModRequirement(goModPath string, modulePath string) (string, string, string, error) {
+	file, err := readGoMod(goModPath)
+	if err != nil {
+		return "", "", "", err
+	}
+	resolved, localDir := resolveRequirement(file, filepath.Dir(goModPath), modulePath)
+	if resolved.Version == "" && localDir == "" {
+		return "", "", "", fmt.Errorf("%s does not require %s", goModPath, modulePath)
+	}
+	return resolved.Path, resolved.Version, localDir, nil
+}
+
+// resolveRequirement applies the replace directives of a go.mod file in dir
+// to its requirement of modulePath, whose version is empty when the file
+// does not require it.
+func resolveRequirement(file *modfile.File, dir string, modulePath string) (module.Version, string) {
+	required := module.Version{Path: modulePath}
+	for _, req := range file.Require {
+		if req.Mod.Path == modulePath {
+			required.Version = req.Mod.Version
+		}
+	}
+	for _, rep := range file.Replace {
+		if rep.Old.Path != modulePath || rep.Old.Version != "" && rep.Old.Version != required.Version {
+			continue
+		}
+		if rep.New.Version == "" {
+			localDir := rep.New.Path
+			if !filepath.IsAbs(localDir) {
+				localDir = filepath.Join(dir, localDir)
+			}
+			return module.Version{Path: modulePath}, localDir
+		}
+		return rep.New, ""
+	}
+	return required, ""
+}
+
+func (p *Processor) processNPMPackage(name string, version string) error {
+	registry := DefaultNPMRegistry
+	if p.config.NPMRegistryURL != "" {
+		registry = strings.TrimSuffix(p.config.NPMRegistryURL, "/")
+	}
+	// The abbreviated metadata lists every version with its tarball.
+	var meta struct {
+		DistTags map[string]string `json:"dist-tags"`
+		Versions map[string]struct {
+			Dist struct {
+				Tarball string `json:"tarball"`
+			} `json:"dist"`
+		} `json:"versions"`
+	}
+	if err := p.fetchJSON(registry+"/"+url.PathEscape(name), "application/vnd.npm.install-v1+json", &meta); err != nil {
+		return fmt.Errorf("failed to look up npm package %s: %w", name, err)
+	}
+	if version == "" {
+		version = "latest"
+	}
+	if tagged, ok := meta.DistTags[version]; ok {
+		version = tagged
+	}
+	release, ok := meta.Versions[version]
+	if !ok || release.Dist.Tarball == "" {
+		return fmt.Errorf("npm package %s has no version %s", name, version)
+	}
+	return p.processPackageArchive(release.Dist.Tarball, "")
+}
+
+func (p *Processor) processPyPIPackage(name string, version string) error {
+	index := DefaultPyPIURL
+	if p.config.PyPIURL != "" {
+		index = strings.TrimSuffix(p.config.PyPIURL, "/")
+	}
+	endpoint := index + "/pypi/" + url.PathEscape(name) + "/json"
+	if version != "" {
+		endpoint = index + "/pypi/" + url.PathEscape(name) + "/" + url.PathEscape(version) + "/json"
+	}
+	var meta struct {
+		URLs []struct {
+			PackageType string `json:"packagetype"`
+			URL         string `json:"url"`
+		} `json:"urls"`
+	}
+	if err := p.fetchJSON(endpoint, "", &meta); err != nil {
+		return fmt.Errorf("failed to look up PyPI package %s: %w", name, err)
+	}
+	// Prefer the source distribution; wheels are zip files too and hold at
+	// least the Python sources.
+	archiveURL := ""
+	for _, packageType := range []string{"sdist", "bdist_wheel"} {
+		for _, file := range meta.URLs {
+			if file.PackageType == packageType && archiveURL == "" {
+				archiveURL = file.URL
+			}
+		}
+	}
+	if archiveURL == "" {
+		return fmt.Errorf("PyPI package %s has no downloadable release files", name)
+	}
+	return p.processPackageArchive(archiveURL, "")
+}
+
+// processPackageArchive downloads and processes a package archive from its
+// root, a slash-separated path inside the archive, or when root is empty
+// from its single top-level directory such as package/ for npm tarballs.
+func (p *Processor) processPackageArchive(archiveURL string, root string) error {
+	file, cleanup, err := p.downloadArchive(archiveURL)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	dir, err := p.extractArchive(file)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if root == "" {
+		return p.ProcessDirectory(archiveRoot(dir))
+	}
+	return p.ProcessDirectory(filepath.Join(dir, filepath.FromSlash(root)))
+}
+
+// fetchJSON decodes the JSON document at endpoint into v.
+func (p *Processor) fetchJSON(endpoint string, accept string, v any) error {
+	if accept == "" {
+		accept = "application/json"
+	}
+	body, err := p.registryGet(endpoint, accept)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+// fetchText returns the plain text document at endpoint.
+func (p *Processor) fetchText(endpoint string) (string, error) {
+	body, err := p.registryGet(endpoint, "text/plain")
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, maxPageBytes))
+	return string(data), err
+}
+
+func (p *Processor) registryGet(endpoint string, accept string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := p.config.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", accept)
+	resp, err := p.webClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			return nil, fmt.Errorf("not found")
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,346 @@
+package aicontext
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// registryServer serves fixed bodies by path, with {{server}} replaced by
+// the server URL, and records the paths requested.
+type registryServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requested []string
+}
+
+func newRegistryServer(t *testing.T, routes map[string][]byte) *registryServer {
+	t.Helper()
+	s := &registryServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requested = append(s.requested, r.URL.EscapedPath())
+		s.mu.Unlock()
+		body, ok := routes[r.URL.EscapedPath()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(bytes.ReplaceAll(body, []byte("{{server}}"), []byte(s.URL)))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *registryServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requested)
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessGoModule(t *testing.T) {
+	moduleZip := func(root string) []byte {
+		return zipBytes(t, testEntry{name: root + "/lib.go", body: "package lib // " + root + "\n"})
+	}
+	routes := map[string][]byte{
+		"/example.com/!lib/@v/list":                                       []byte("v1.2.0\nv1.10.0\nv1.11.0-rc.1\nnot-a-version\n"),
+		"/example.com/!lib/@v/v1.10.0.zip":                                moduleZip("example.com/Lib@v1.10.0"),
+		"/example.com/!lib/@v/v1.2.0.zip":                                 moduleZip("example.com/Lib@v1.2.0"),
+		"/example.com/pre/@v/list":                                        []byte("v0.2.0-beta.1\nv0.1.0-alpha\n"),
+		"/example.com/pre/@v/v0.2.0-beta.1.zip":                           zipBytes(t, testEntry{name: "example.com/pre@v0.2.0-beta.1/pre.go", body: "package pre\n"}),
+		"/example.com/untagged/@v/list":                                   nil,
+		"/example.com/untagged/@latest":                                   []byte(`{"Version":"v0.0.0-20240101000000-abcdefabcdef"}`),
+		"/example.com/untagged/@v/v0.0.0-20240101000000-abcdefabcdef.zip": zipBytes(t, testEntry{name: "example.com/untagged@v0.0.0-20240101000000-abcdefabcdef/u.go", body: "package u\n"}),
+	}
+	tests := []struct {
+		name         string
+		source       string
+		wantFiles    map[string]string
+		wantRequests []string
+	}{
+		{
+			name:         "highest release over pre-releases",
+			source:       "go:example.com/Lib",
+			wantFiles:    map[string]string{"lib.go": "package lib // example.com/Lib@v1.10.0\n"},
+			wantRequests: []string{"/example.com/!lib/@v/list", "/example.com/!lib/@v/v1.10.0.zip"},
+		},
+		{
+			name:         "explicit version",
+			source:       "go:example.com/Lib@v1.2.0",
+			wantFiles:    map[string]string{"lib.go": "package lib // example.com/Lib@v1.2.0\n"},
+			wantRequests: []string{"/example.com/!lib/@v/v1.2.0.zip"},
+		},
+		{
+			name:         "pre-release when nothing is released",
+			source:       "go:example.com/pre@latest",
+			wantFiles:    map[string]string{"pre.go": "package pre\n"},
+			wantRequests: []string{"/example.com/pre/@v/list", "/example.com/pre/@v/v0.2.0-beta.1.zip"},
+		},
+		{
+			name:      "pseudo-version from @latest without tags",
+			source:    "go:example.com/untagged",
+			wantFiles: map[string]string{"u.go": "package u\n"},
+			wantRequests: []string{
+				"/example.com/untagged/@v/list", "/example.com/untagged/@latest",
+				"/example.com/untagged/@v/v0.0.0-20240101000000-abcdefabcdef.zip",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRegistryServer(t, routes)
+			p := NewProcessor(ProcessorConfig{GoProxyURL: server.URL + "/"})
+			output := processToJSON(t, p, func() error { return p.ProcessPackage(tt.source) })
+			assertPackageFiles(t, output, tt.wantFiles)
+			if got := server.requests(); !slices.Equal(got, tt.wantRequests) {
+				t.Errorf("requested %q, want %q", got, tt.wantRequests)
+			}
+		})
+	}
+
+	t.Run("unknown module", func(t *testing.T) {
+		server := newRegistryServer(t, routes)
+		p := NewProcessor(ProcessorConfig{GoProxyURL: server.URL})
+		if err := p.ProcessPackage("go:example.com/missing"); err == nil {
+			t.Error("missing module was processed")
+		}
+	})
+}
+
+func TestProcessGoModuleGoMod(t *testing.T) {
+	dir := t.TempDir()
+	goMod := `module example.com/app
+
+require (
+	example.com/Lib v1.2.0
+	example.com/old v1.0.0
+	example.com/local v1.0.0
+	example.com/pinned v1.5.0
+)
+
+replace example.com/old => example.com/fork v1.0.1
+
+replace example.com/local => ./third_party/local
+
+replace example.com/pinned v1.4.0 => ./unused
+`
+	writeTree(t, dir, map[string]string{
+		"go.mod":                     goMod,
+		"third_party/local/local.go": "package local\n",
+	})
+	routes := map[string][]byte{
+		"/example.com/!lib/@v/v1.2.0.zip":   zipBytes(t, testEntry{name: "example.com/Lib@v1.2.0/lib.go", body: "package lib\n"}),
+		"/example.com/fork/@v/v1.0.1.zip":   zipBytes(t, testEntry{name: "example.com/fork@v1.0.1/fork.go", body: "package fork\n"}),
+		"/example.com/pinned/@v/v1.5.0.zip": zipBytes(t, testEntry{name: "example.com/pinned@v1.5.0/pinned.go", body: "package pinned\n"}),
+		"/example.com/!lib/@v/v1.9.0.zip":   zipBytes(t, testEntry{name: "example.com/Lib@v1.9.0/lib.go", body: "package lib // v1.9.0\n"}),
+	}
+	tests := []struct {
+		name         string
+		source       string
+		wantFiles    map[string]string
+		wantRequests []string
+	}{
+		{
+			name:         "required version",
+			source:       "go:example.com/Lib",
+			wantFiles:    map[string]string{"lib.go": "package lib\n"},
+			wantRequests: []string{"/example.com/!lib/@v/v1.2.0.zip"},
+		},
+		{
+			name:         "replaced by another module",
+			source:       "go:example.com/old",
+			wantFiles:    map[string]string{"fork.go": "package fork\n"},
+			wantRequests: []string{"/example.com/fork/@v/v1.0.1.zip"},
+		},
+		{
+			name:      "replaced by a local directory",
+			source:    "go:example.com/local",
+			wantFiles: map[string]string{"local.go": "package local\n"},
+		},
+		{
+			name:         "replace of another version ignored",
+			source:       "go:example.com/pinned",
+			wantFiles:    map[string]string{"pinned.go": "package pinned\n"},
+			wantRequests: []string{"/example.com/pinned/@v/v1.5.0.zip"},
+		},
+		{
+			name:         "explicit version over go.mod",
+			source:       "go:example.com/Lib@v1.9.0",
+			wantFiles:    map[string]string{"lib.go": "package lib // v1.9.0\n"},
+			wantRequests: []string{"/example.com/!lib/@v/v1.9.0.zip"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRegistryServer(t, routes)
+			p := NewProcessor(ProcessorConfig{GoProxyURL: server.URL, GoModFile: filepath.Join(dir, "go.mod")})
+			output := processToJSON(t, p, func() error { return p.ProcessPackage(tt.source) })
+			assertPackageFiles(t, output, tt.wantFiles)
+			if got := server.requests(); !slices.Equal(got, tt.wantRequests) {
+				t.Errorf("requested %q, want %q", got, tt.wantRequests)
+			}
+		})
+	}
+
+	t.Run("not required", func(t *testing.T) {
+		p := NewProcessor(ProcessorConfig{GoProxyURL: newRegistryServer(t, routes).URL, GoModFile: filepath.Join(dir, "go.mod")})
+		err := p.ProcessPackage("go:example.com/other")
+		if err == nil || !strings.Contains(err.Error(), "does not require example.com/other") {
+			t.Errorf("got error %v", err)
+		}
+	})
+}
+
+func TestProcessNPMPackage(t *testing.T) {
+	tarball := func(version string) []byte {
+		return gzipBytes(t, tarBytes(t,
+			testEntry{name: "package/package.json", body: `{"version":"` + version + `"}`},
+			testEntry{name: "package/index.js", body: "module.exports = '" + version + "';\n"},
+		))
+	}
+	routes := map[string][]byte{
+		"/@scope%2Flib": []byte(`{
+			"dist-tags": {"latest": "1.1.0", "next": "2.0.0-rc.1"},
+			"versions": {
+				"1.0.0": {"dist": {"tarball": "{{server}}/tarballs/lib-1.0.0.tgz"}},
+				"1.1.0": {"dist": {"tarball": "{{server}}/tarballs/lib-1.1.0.tgz"}},
+				"2.0.0-rc.1": {"dist": {"tarball": "{{server}}/tarballs/lib-2.0.0-rc.1.tgz"}},
+				"3.0.0": {"dist": {}}
+			}
+		}`),
+		"/tarballs/lib-1.0.0.tgz":      tarball("1.0.0"),
+		"/tarballs/lib-1.1.0.tgz":      tarball("1.1.0"),
+		"/tarballs/lib-2.0.0-rc.1.tgz": tarball("2.0.0-rc.1"),
+	}
+	tests := []struct {
+		name        string
+		source      string
+		wantVersion string
+		wantErr     bool
+	}{
+		{name: "latest tag by default", source: "npm:@scope/lib", wantVersion: "1.1.0"},
+		{name: "dist-tag", source: "npm:@scope/lib@next", wantVersion: "2.0.0-rc.1"},
+		{name: "exact version", source: "npm:@scope/lib@1.0.0", wantVersion: "1.0.0"},
+		{name: "unknown version", source: "npm:@scope/lib@9.9.9", wantErr: true},
+		{name: "version without tarball", source: "npm:@scope/lib@3.0.0", wantErr: true},
+		{name: "unknown package", source: "npm:missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRegistryServer(t, routes)
+			p := NewProcessor(ProcessorConfig{NPMRegistryURL: server.URL})
+			if tt.wantErr {
+				t.Setenv("HOME", t.TempDir())
+				if err := p.ProcessPackage(tt.source); err == nil {
+					t.Error("got no error")
+				}
+				return
+			}
+			output := processToJSON(t, p, func() error { return p.ProcessPackage(tt.source) })
+			assertPackageFiles(t, output, map[string]string{
+				"package.json": `{"version":"` + tt.wantVersion + `"}`,
+				"index.js":     "module.exports = '" + tt.wantVersion + "';\n",
+			})
+		})
+	}
+}
+
+func TestProcessPyPIPackage(t *testing.T) {
+	sdist := gzipBytes(t, tarBytes(t, testEntry{name: "demo-1.0/demo.py", body: "# sdist 1.0\n"}))
+	release := func(urls string) []byte { return []byte(`{"urls": [` + urls + `]}`) }
+	routes := map[string][]byte{
+		"/pypi/demo/json": release(`
+			{"packagetype": "bdist_wheel", "url": "{{server}}/files/demo-2.0-py3-none-any.whl"},
+			{"packagetype": "sdist", "url": "{{server}}/files/demo-2.0.tar.gz"}`),
+		"/pypi/demo/1.0/json":                release(`{"packagetype": "sdist", "url": "{{server}}/files/demo-1.0.tar.gz"}`),
+		"/pypi/wheels/json":                  release(`{"packagetype": "bdist_wheel", "url": "{{server}}/files/wheels-1.0-py3-none-any.whl"}`),
+		"/pypi/empty/json":                   release(``),
+		"/files/demo-2.0.tar.gz":             gzipBytes(t, tarBytes(t, testEntry{name: "demo-2.0/demo.py", body: "# sdist 2.0\n"})),
+		"/files/demo-1.0.tar.gz":             sdist,
+		"/files/demo-2.0-py3-none-any.whl":   zipBytes(t, testEntry{name: "demo/__init__.py", body: "# wheel 2.0\n"}),
+		"/files/wheels-1.0-py3-none-any.whl": zipBytes(t, testEntry{name: "wheels/__init__.py", body: "# wheel\n"}),
+	}
+	tests := []struct {
+		name         string
+		source       string
+		wantFiles    map[string]string
+		wantRequests []string
+		wantErr      bool
+	}{
+		{
+			name:         "sdist over wheel",
+			source:       "pypi:demo",
+			wantFiles:    map[string]string{"demo.py": "# sdist 2.0\n"},
+			wantRequests: []string{"/pypi/demo/json", "/files/demo-2.0.tar.gz"},
+		},
+		{
+			name:         "versioned endpoint",
+			source:       "pypi:demo==1.0",
+			wantFiles:    map[string]string{"demo.py": "# sdist 1.0\n"},
+			wantRequests: []string{"/pypi/demo/1.0/json", "/files/demo-1.0.tar.gz"},
+		},
+		{
+			name:         "wheel without sdist",
+			source:       "pypi:wheels",
+			wantFiles:    map[string]string{"__init__.py": "# wheel\n"},
+			wantRequests: []string{"/pypi/wheels/json", "/files/wheels-1.0-py3-none-any.whl"},
+		},
+		{name: "no release files", source: "pypi:empty", wantErr: true},
+		{name: "unknown version", source: "pypi:demo==9.9", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRegistryServer(t, routes)
+			p := NewProcessor(ProcessorConfig{PyPIURL: server.URL})
+			if tt.wantErr {
+				t.Setenv("HOME", t.TempDir())
+				if err := p.ProcessPackage(tt.source); err == nil {
+					t.Error("got no error")
+				}
+				return
+			}
+			output := processToJSON(t, p, func() error { return p.ProcessPackage(tt.source) })
+			assertPackageFiles(t, output, tt.wantFiles)
+			if got := server.requests(); !slices.Equal(got, tt.wantRequests) {
+				t.Errorf("requested %q, want %q", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+// assertPackageFiles checks that output holds exactly the wanted files,
+// by slash-separated path and content.
+func assertPackageFiles(t *testing.T, output *Output, want map[string]string) {
+	t.Helper()
+	got := make(map[string]string)
+	for _, file := range output.Files {
+		got[filepath.ToSlash(file.Path)] = file.Content
+	}
+	if len(got) != len(want) {
+		t.Errorf("got files %v, want %v", outputPaths(output), want)
+	}
+	for path, content := range want {
+		if got[path] != content {
+			t.Errorf("%s has content %q, want %q", path, got[path], content)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package aicontext
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// remoteProvider knows how one kind of git host lays out its web URLs and
+// which token grants access to it.
+type remoteProvider struct {
+	name string
+	// hosts are the public instances; self-hosted ones are recognized by
+	// their URL shape through shape.
+	hosts []string
+	shape *regexp.Regexp
+	// split separates the URL path into the repository path and the
+	// segments naming a ref and sub-path.
+	split     func(segments []string) (repo []string, refPath []string)
+	tokenEnvs []string
+	// tokenUser is the basic auth username the host expects with a token.
+	tokenUser string
+}
+
+var remoteProviders = []remoteProvider{
+	{
+		name:      "github",
+		hosts:     []string{"github.com"},
+		split:     splitAt(2, "tree", "blob"),
+		tokenEnvs: []string{"GH_TOKEN", "GITHUB_TOKEN"},
+		tokenUser: "git", // can be anything but not empty
+	},
+	{
+		name:      "gitlab",
+		hosts:     []string{"gitlab.com"},
+		shape:     regexp.MustCompile(`/-/(tree|blob)/`),
+		split:     splitGitLab,
+		tokenEnvs: []string{"GITLAB_TOKEN"},
+		tokenUser: "oauth2",
+	},
+	{
+		name:      "gitea",
+		hosts:     []string{"gitea.com", "codeberg.org"},
+		shape:     regexp.MustCompile(`/src/(branch|tag|commit)/`),
+		split:     splitGitea,
+		tokenEnvs: []string{"GITEA_TOKEN"},
+		tokenUser: "git",
+	},
+	{
+		name:      "bitbucket",
+		hosts:     []string{"bitbucket.org"},
+		split:     splitAt(2, "src"),
+		tokenEnvs: []string{"BITBUCKET_TOKEN"},
+		tokenUser: "x-token-auth",
+	},
+}
+
+// genericProvider handles any other remote given by its clone URL, such as
+// https://git.example.com/team/project.git.
+var genericProvider = remoteProvider{
+	name:      "git",
+	split:     splitGeneric,
+	tokenUser: "git",
+}
+
+var scpLikeRegex = regexp.MustCompile(`^([a-zA-Z0-9._-]+@)?([a-zA-Z0-9.-]+):([^/].*)$`)
+
+// portRegex matches a digit-only first path segment, which makes
+// host:8080/owner/repo a host and port rather than an scp-like remote.
+var portRegex = regexp.MustCompile(`^[0-9]+(/|$)`)
+
+// cloneURLPathRegex matches repository paths ending in .git, optionally
+// with an @ref, as in clone URLs of unknown hosts.
+var cloneURLPathRegex = regexp.MustCompile(`\.git(@[^/]+)?(/|$)`)
+
+// hostEnvRegex matches the characters of a host replaced by _ in token
+// variable names.
+var hostEnvRegex = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+func findProvider(host string, path string) remoteProvider {
+	for _, provider := range remoteProviders {
+		if slices.Contains(provider.hosts, host) {
+			return provider
+		}
+	}
+	for _, provider := range remoteProviders {
+		if provider.shape != nil && provider.shape.MatchString(path) {
+			return provider
+		}
+	}
+	return genericProvider
+}
+
+// matchSCPLike returns the submatches of scpLikeRegex for an scp-like SSH
+// remote such as git@host:owner/repo, or nil for anything else.
+func matchSCPLike(rawURL string) []string {
+	if strings.Contains(rawURL, "://") {
+		return nil
+	}
+	match := scpLikeRegex.FindStringSubmatch(rawURL)
+	if match == nil || portRegex.MatchString(match[3]) {
+		return nil
+	}
+	return match
+}
+
+// isGitRemote reports whether a URL names a git repository: an SSH remote,
+// a URL on a known host or with a known web shape, or a .git clone URL.
+// Self-hosted GitLab and Gitea instances cannot be told apart from other
+// sites by their bare project URLs, so those are treated as web pages; their
+// /-/tree/ and /src/branch/ URLs and .git clone URLs are recognized.
+func isGitRemote(rawURL string) bool {
+	if matchSCPLike(rawURL) != nil {
+		return true
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	if parsed.Scheme == "ssh" || parsed.Scheme == "git" {
+		return true
+	}
+	if findProvider(parsed.Hostname(), parsed.Path).name != genericProvider.name {
+		return true
+	}
+	return cloneURLPathRegex.MatchString(parsed.Path)
+}
+
+// parseGitURL turns a web or clone URL into the repository to clone and the
+// ref and sub-path it points at.
+func parseGitURL(rawURL string) (*gitSource, error) {
+	var parsed *url.URL
+	if match := matchSCPLike(rawURL); match != nil {
+		parsed = &url.URL{Scheme: "ssh", User: url.User(strings.TrimSuffix(match[1], "@")), Host: match[2], Path: "/" + match[3]}
+		if match[1] == "" {
+			parsed.User = nil
+		}
+	} else {
+		var err error
+		if parsed, err = url.Parse(rawURL); err != nil {
+			return nil, fmt.Errorf("failed to parse url: %w", err)
+		}
+	}
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	provider := findProvider(parsed.Hostname(), parsed.Path)
+	repo, refPath := provider.split(segments)
+	if len(repo) == 0 || repo[0] == "" {
+		return nil, fmt.Errorf("no repository path in %q", rawURL)
+	}
+	source := &gitSource{host: parsed.Hostname(), provider: provider, refPath: refPath}
+	last := repo[len(repo)-1]
+	if name, ref, ok := strings.Cut(last, "@"); ok {
+		last, source.ref = name, ref
+	}
+	repo[len(repo)-1] = last
+	source.repoPath = strings.TrimSuffix(strings.Join(repo, "/"), ".git")
+	cloneURL := *parsed
+	cloneURL.Path = "/" + strings.Join(repo, "/")
+	cloneURL.RawQuery, cloneURL.Fragment = "", ""
+	if provider.name != genericProvider.name {
+		cloneURL.Path = strings.TrimSuffix(cloneURL.Path, ".git")
+	}
+	source.cloneURL = cloneURL.String()
+	if parsed.Scheme == "ssh" && !strings.Contains(rawURL, "://") {
+		// Keep the scp-like form, which go-git and ssh configs expect.
+		source.cloneURL = strings.TrimPrefix(source.cloneURL, "ssh://")
+		source.cloneURL = strings.Replace(source.cloneURL, parsed.Host+"/", parsed.Host+":", 1)
+	}
+	return source, nil
+}
+
+// token returns the access token for the source's host. The per-host
+// AI_CONTEXT_GIT_TOKEN_<HOST> variable (host upper-cased, other characters
+// replaced by _) wins over the provider's own variables.
+func (s *gitSource) token() string {
+	for _, env := range s.tokenEnvs() {
+		if token := os.Getenv(env); token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+func (s *gitSource) tokenEnvs() []string {
+	hostEnv := "AI_CONTEXT_GIT_TOKEN_" + strings.ToUpper(hostEnvRegex.ReplaceAllString(s.host, "_"))
+	return append([]string{hostEnv}, s.provider.tokenEnvs...)
+}
+
+func (s *gitSource) isSSH() bool {
+	return strings.HasPrefix(s.cloneURL, "ssh://") || !strings.Contains(s.cloneURL, "://")
+}
+
+// splitAt handles hosts where the repository is org/repo and the ref and
+// path follow one of markers at position index.
+func splitAt(index int, markers ...string) func([]string) ([]string, []string) {
+	return func(segments []string) ([]string, []string) {
+		if len(segments) <= index {
+			return segments, nil
+		}
+		if len(segments) > index+1 && slices.Contains(markers, segments[index]) {
+			return segments[:index], segments[index+1:]
+		}
+		return segments[:index], nil
+	}
+}
+
+// splitGitLab handles nested groups, where everything before /-/ is the
+// repository path.
+func splitGitLab(segments []string) ([]string, []string) {
+	i := slices.Index(segments, "-")
+	if i < 0 {
+		return segments, nil
+	}
+	if len(segments) > i+2 && (segments[i+1] == "tree" || segments[i+1] == "blob") {
+		return segments[:i], segments[i+2:]
+	}
+	return segments[:i], nil
+}
+
+func splitGitea(segments []string) ([]string, []string) {
+	if len(segments) > 4 && segments[2] == "src" && slices.Contains([]string{"branch", "tag", "commit"}, segments[3]) {
+		return segments[:2], segments[4:]
+	}
+	return splitAt(2)(segments)
+}
+
+// splitGeneric treats everything up to a segment ending in .git as the
+// repository path.
+func splitGeneric(segments []string) ([]string, []string) {
+	for i, segment := range segments {
+		name, _, _ := strings.Cut(segment, "@")
+		if strings.HasSuffix(name, ".git") {
+			return segments[:i+1], nil
+		}
+	}
+	return segments, nil
+}
@@ -0,0 +1,118 @@
+package aicontext
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProviderSplit(t *testing.T) {
+	tests := []struct {
+		name        string
+		split       func([]string) ([]string, []string)
+		path        string
+		wantRepo    string
+		wantRefPath string
+	}{
+		{"github repo", splitAt(2, "tree", "blob"), "o/r", "o/r", ""},
+		{"github tree", splitAt(2, "tree", "blob"), "o/r/tree/feature/login/src", "o/r", "feature/login/src"},
+		{"github blob", splitAt(2, "tree", "blob"), "o/r/blob/main/README.md", "o/r", "main/README.md"},
+		{"github bare tree", splitAt(2, "tree", "blob"), "o/r/tree", "o/r", ""},
+		{"github other page", splitAt(2, "tree", "blob"), "o/r/issues/1", "o/r", ""},
+		{"github owner only", splitAt(2, "tree", "blob"), "o", "o", ""},
+		{"gitlab repo", splitGitLab, "g/sub/p", "g/sub/p", ""},
+		{"gitlab tree", splitGitLab, "g/sub/p/-/tree/main/src", "g/sub/p", "main/src"},
+		{"gitlab blob", splitGitLab, "g/p/-/blob/v1.0/a.go", "g/p", "v1.0/a.go"},
+		{"gitlab other page", splitGitLab, "g/p/-/issues/1", "g/p", ""},
+		{"gitea repo", splitGitea, "o/r", "o/r", ""},
+		{"gitea branch", splitGitea, "o/r/src/branch/main/docs", "o/r", "main/docs"},
+		{"gitea tag", splitGitea, "o/r/src/tag/v1.0", "o/r", "v1.0"},
+		{"gitea commit", splitGitea, "o/r/src/commit/0123456/a.go", "o/r", "0123456/a.go"},
+		{"gitea other page", splitGitea, "o/r/issues", "o/r", ""},
+		{"bitbucket repo", splitAt(2, "src"), "o/r", "o/r", ""},
+		{"bitbucket src", splitAt(2, "src"), "o/r/src/main/lib", "o/r", "main/lib"},
+		{"generic", splitGeneric, "team/sub/project.git", "team/sub/project.git", ""},
+		{"generic with ref", splitGeneric, "team/project.git@v1/extra", "team/project.git@v1", ""},
+		{"generic without .git", splitGeneric, "team/project", "team/project", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, refPath := tt.split(strings.Split(tt.path, "/"))
+			if strings.Join(repo, "/") != tt.wantRepo || strings.Join(refPath, "/") != tt.wantRefPath {
+				t.Errorf("got %v and %v, want %q and %q", repo, refPath, tt.wantRepo, tt.wantRefPath)
+			}
+		})
+	}
+
+	// Providers use the split matching their web URLs.
+	for _, provider := range remoteProviders {
+		if repo, _ := provider.split([]string{"o", "r"}); !slices.Equal(repo, []string{"o", "r"}) {
+			t.Errorf("%s splits o/r into %v", provider.name, repo)
+		}
+	}
+}
+
+func TestParseGitURL(t *testing.T) {
+	tests := []struct {
+		url          string
+		wantProvider string
+		wantClone    string
+		wantRepo     string
+		wantRef      string
+		wantRefPath  string
+	}{
+		{"https://github.com/o/r", "github", "https://github.com/o/r", "o/r", "", ""},
+		{"https://github.com/o/r.git", "github", "https://github.com/o/r", "o/r", "", ""},
+		{"https://github.com/o/r/tree/feature/login/src", "github", "https://github.com/o/r", "o/r", "", "feature/login/src"},
+		{"https://github.com/o/r/blob/main/README.md?plain=1#L3", "github", "https://github.com/o/r", "o/r", "", "main/README.md"},
+		{"https://github.com/o/r@v1.0", "github", "https://github.com/o/r", "o/r", "v1.0", ""},
+		{"https://gitlab.com/g/sub/p", "gitlab", "https://gitlab.com/g/sub/p", "g/sub/p", "", ""},
+		{"https://gitlab.com/g/sub/p/-/tree/main/docs", "gitlab", "https://gitlab.com/g/sub/p", "g/sub/p", "", "main/docs"},
+		{"https://gitlab.example.com/g/p/-/blob/v1/a.go", "gitlab", "https://gitlab.example.com/g/p", "g/p", "", "v1/a.go"},
+		{"https://codeberg.org/o/r/src/branch/main/lib", "gitea", "https://codeberg.org/o/r", "o/r", "", "main/lib"},
+		{"https://git.example.com/o/r/src/tag/v2", "gitea", "https://git.example.com/o/r", "o/r", "", "v2"},
+		{"https://bitbucket.org/o/r/src/main/lib", "bitbucket", "https://bitbucket.org/o/r", "o/r", "", "main/lib"},
+		{"https://git.example.com/team/sub/project.git", "git", "https://git.example.com/team/sub/project.git", "team/sub/project", "", ""},
+		{"https://git.example.com/team/project.git@v1.0", "git", "https://git.example.com/team/project.git", "team/project", "v1.0", ""},
+		{"https://git.example.com:8443/team/project.git", "git", "https://git.example.com:8443/team/project.git", "team/project", "", ""},
+		{"git@github.com:o/r.git", "github", "git@github.com:o/r", "o/r", "", ""},
+		{"deploy@git.example.com:team/project.git", "git", "deploy@git.example.com:team/project.git", "team/project", "", ""},
+		{"ssh://git@git.example.com:2222/team/project.git", "git", "ssh://git@git.example.com:2222/team/project.git", "team/project", "", ""},
+		{"git://git.example.com/project.git", "git", "git://git.example.com/project.git", "project", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if !isGitRemote(tt.url) {
+				t.Errorf("not recognized as a git remote")
+			}
+			s, err := parseGitURL(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.provider.name != tt.wantProvider || s.cloneURL != tt.wantClone || s.repoPath != tt.wantRepo || s.ref != tt.wantRef || strings.Join(s.refPath, "/") != tt.wantRefPath {
+				t.Errorf("got %s %s %s ref %q path %v", s.provider.name, s.cloneURL, s.repoPath, s.ref, s.refPath)
+			}
+		})
+	}
+}
+
+func TestIsGitRemoteNegatives(t *testing.T) {
+	for _, rawURL := range []string{
+		// A host and port without a scheme is not an scp-like remote.
+		"git.example.com:8080/owner/repo",
+		"localhost:3000",
+		// Bare project URLs of self-hosted instances look like any web page.
+		"https://gitlab.example.com/group/project",
+		"https://git.example.com/owner/repo",
+		"https://example.com/docs/page.html",
+		"./local/dir",
+		"/abs/path",
+	} {
+		if isGitRemote(rawURL) {
+			t.Errorf("%q recognized as a git remote", rawURL)
+		}
+	}
+	if s, err := parseGitURL("git.example.com:8080/owner/repo"); err == nil {
+		t.Errorf("host and port parsed as %s", s.cloneURL)
+	}
+}
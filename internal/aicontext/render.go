@@ -0,0 +1,506 @@
+package aicontext
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Renderer writes an Output in one output format.
+type Renderer interface {
+	Render(w io.Writer, output *Output) error
+	// Extension is the file extension used for outputs of this format.
+	Extension() string
+}
+
+var renderers = map[string]Renderer{
+	"markdown": markdownRenderer{},
+	"xml":      xmlRenderer{},
+	"json":     jsonRenderer{},
+	"jsonl":    jsonlRenderer{},
+	"txt":      textRenderer{},
+}
+
+// OutputFormats lists the names accepted by --format.
+var OutputFormats = []string{"markdown", "xml", "json", "jsonl", "txt"}
+
+// ValidateFormat reports an error for format names without a renderer. The
+// empty string selects markdown.
+func ValidateFormat(format string) error {
+	if _, ok := renderers[format]; !ok && format != "" {
+		return fmt.Errorf("unknown output format %q (expected one of %s)", format, strings.Join(OutputFormats, ", "))
+	}
+	return nil
+}
+
+func (p *Processor) renderer() Renderer {
+	if p.template != nil {
+		return newTemplateRenderer(p.template)
+	}
+	if r, ok := renderers[p.config.Format]; ok {
+		return r
+	}
+	return markdownRenderer{}
+}
+
+// outputPath swaps the .md extension chosen by the handler for the one of
+// the selected format, unless the user picked the path.
+func (p *Processor) outputPath() string {
+	if p.config.FixedOutputPath {
+		return p.config.OutputPath
+	}
+	ext := filepath.Ext(p.config.OutputPath)
+	return strings.TrimSuffix(p.config.OutputPath, ext) + p.renderer().Extension()
+}
+
+type markdownRenderer struct{}
+
+func (markdownRenderer) Render(w io.Writer, output *Output) error {
+	return executeTemplate(w, builtinTemplate, output)
+}
+
+func (markdownRenderer) Extension() string { return ".md" }
+
+type jsonRenderer struct{}
+
+func (jsonRenderer) Render(w io.Writer, output *Output) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(output); err != nil {
+		return fmt.Errorf("failed to encode json: %w", err)
+	}
+	return nil
+}
+
+func (jsonRenderer) Extension() string { return ".json" }
+
+// jsonlRenderer writes one FileEntry per line, for retrieval pipelines that
+// index files individually.
+type jsonlRenderer struct{}
+
+func (jsonlRenderer) Render(w io.Writer, output *Output) error {
+	enc := json.NewEncoder(w)
+	if output.Discussion != nil {
+		// Pull requests and issues lead with their conversation.
+		if err := enc.Encode(struct {
+			Discussion *Discussion `json:"discussion"`
+		}{output.Discussion}); err != nil {
+			return fmt.Errorf("failed to encode json line: %w", err)
+		}
+	}
+	if output.Changes != nil {
+		// Diff mode leads with one record holding the change set.
+		if err := enc.Encode(struct {
+			Changes *ChangeSet `json:"changes"`
+		}{output.Changes}); err != nil {
+			return fmt.Errorf("failed to encode json line: %w", err)
+		}
+	}
+	if len(output.History) > 0 {
+		if err := enc.Encode(struct {
+			History []CommitInfo `json:"history"`
+		}{output.History}); err != nil {
+			return fmt.Errorf("failed to encode json line: %w", err)
+		}
+	}
+	for _, file := range output.Files {
+		if err := enc.Encode(file); err != nil {
+			return fmt.Errorf("failed to encode json line: %w", err)
+		}
+	}
+	for _, image := range output.Images {
+		if err := enc.Encode(struct {
+			Image ImageEntry `json:"image"`
+		}{image}); err != nil {
+			return fmt.Errorf("failed to encode json line: %w", err)
+		}
+	}
+	return nil
+}
+
+func (jsonlRenderer) Extension() string { return ".jsonl" }
+
+// xmlRenderer follows the <documents><document> layout recommended for
+// long-context prompts to Claude, with file contents in CDATA sections.
+type xmlRenderer struct{}
+
+type xmlCDATA struct {
+	Text string `xml:",cdata"`
+}
+
+type xmlDocument struct {
+	Index      int        `xml:"index,attr"`
+	Path       string     `xml:"path,attr"`
+	Language   string     `xml:"language,attr,omitempty"`
+	Size       int64      `xml:"size,attr"`
+	Tokens     int        `xml:"tokens,attr"`
+	Truncated  bool       `xml:"truncated,attr,omitempty"`
+	Chunk      string     `xml:"chunk,attr,omitempty"`
+	Derived    string     `xml:"derived,attr,omitempty"`
+	Dependency string     `xml:"dependency,attr,omitempty"`
+	Outline    bool       `xml:"outline,attr,omitempty"`
+	Source     string     `xml:"source"`
+	LastCommit *xmlCommit `xml:"last_commit,omitempty"`
+	Content    xmlCDATA   `xml:"document_content"`
+}
+
+type xmlOmitted struct {
+	Path   string `xml:"path,attr"`
+	Tokens int    `xml:"tokens,attr"`
+	Reason string `xml:"reason,attr"`
+}
+
+type xmlOmittedList struct {
+	Files []xmlOmitted `xml:"file"`
+}
+
+type xmlPartSummary struct {
+	Name    string `xml:"name,attr"`
+	Summary string `xml:",chardata"`
+}
+
+type xmlPart struct {
+	Index    int              `xml:"index,attr"`
+	Count    int              `xml:"count,attr"`
+	TreeFile string           `xml:"tree_file,attr"`
+	Parts    []xmlPartSummary `xml:"part_file"`
+}
+
+type xmlCommit struct {
+	Hash    string    `xml:"hash,attr"`
+	Author  string    `xml:"author,attr"`
+	Date    string    `xml:"date,attr"`
+	Subject string    `xml:"subject,attr"`
+	Body    *xmlCDATA `xml:"body,omitempty"`
+}
+
+type xmlFileChange struct {
+	Path   string `xml:"path,attr"`
+	Status string `xml:"status,attr"`
+	From   string `xml:"from,attr,omitempty"`
+	Diff   string `xml:",cdata"`
+}
+
+type xmlChanges struct {
+	Base       string          `xml:"base,attr"`
+	BaseCommit string          `xml:"base_commit,attr"`
+	Head       string          `xml:"head,attr"`
+	HeadCommit string          `xml:"head_commit,attr"`
+	Commits    []xmlCommit     `xml:"commit"`
+	Files      []xmlFileChange `xml:"diff"`
+}
+
+type xmlDiscussionComment struct {
+	Author string `xml:"author,attr"`
+	Date   string `xml:"date,attr"`
+	State  string `xml:"state,attr,omitempty"`
+	Body   string `xml:",cdata"`
+}
+
+type xmlReviewThread struct {
+	Path     string                 `xml:"path,attr"`
+	Line     int                    `xml:"line,attr,omitempty"`
+	DiffHunk xmlCDATA               `xml:"diff_hunk"`
+	Comments []xmlDiscussionComment `xml:"comment"`
+}
+
+type xmlDiscussion struct {
+	Kind      string                 `xml:"kind,attr"`
+	Number    int                    `xml:"number,attr"`
+	Title     string                 `xml:"title,attr"`
+	URL       string                 `xml:"url,attr"`
+	Author    string                 `xml:"author,attr"`
+	State     string                 `xml:"state,attr"`
+	CreatedAt string                 `xml:"created_at,attr"`
+	Body      *xmlCDATA              `xml:"body,omitempty"`
+	Comments  []xmlDiscussionComment `xml:"comment"`
+	Reviews   []xmlReviewThread      `xml:"review_thread"`
+}
+
+type xmlHistory struct {
+	Commits []xmlCommit `xml:"commit"`
+}
+
+type xmlImage struct {
+	Path string `xml:"path,attr"`
+	Link string `xml:"link,attr"`
+	Size int64  `xml:"size,attr"`
+}
+
+type xmlImages struct {
+	Images []xmlImage `xml:"image"`
+}
+
+type xmlContext struct {
+	XMLName        xml.Name        `xml:"context"`
+	GenerationDate string          `xml:"generation_date,attr"`
+	FileCount      int             `xml:"file_count,attr"`
+	TotalSize      int64           `xml:"total_size,attr"`
+	TotalTokens    int             `xml:"total_tokens,attr"`
+	Part           *xmlPart        `xml:"part,omitempty"`
+	Omitted        *xmlOmittedList `xml:"omitted,omitempty"`
+	Discussion     *xmlDiscussion  `xml:"discussion,omitempty"`
+	Changes        *xmlChanges     `xml:"changes,omitempty"`
+	History        *xmlHistory     `xml:"history,omitempty"`
+	DirectoryTree  *xmlCDATA       `xml:"directory_tree,omitempty"`
+	Images         *xmlImages      `xml:"images,omitempty"`
+	Documents      []xmlDocument   `xml:"documents>document"`
+	Dependencies   []xmlDocument   `xml:"dependencies>document"`
+}
+
+func (xmlRenderer) Render(w io.Writer, output *Output) error {
+	doc := xmlContext{
+		GenerationDate: output.GenerationDate,
+		FileCount:      output.FileCount,
+		TotalSize:      output.TotalSize,
+		TotalTokens:    output.TotalTokens,
+	}
+	if output.Part != nil {
+		doc.Part = &xmlPart{Index: output.Part.Index, Count: output.Part.Count, TreeFile: output.Part.TreeFile}
+		for _, summary := range output.Part.Parts {
+			doc.Part.Parts = append(doc.Part.Parts, xmlPartSummary{Name: summary.Name, Summary: summary.Summary})
+		}
+	}
+	if len(output.Omitted) > 0 {
+		doc.Omitted = &xmlOmittedList{}
+		for _, entry := range output.Omitted {
+			doc.Omitted.Files = append(doc.Omitted.Files, xmlOmitted{Path: entry.Path, Tokens: entry.Tokens, Reason: entry.Reason})
+		}
+	}
+	if discussion := output.Discussion; discussion != nil {
+		doc.Discussion = &xmlDiscussion{
+			Kind:      discussion.Kind,
+			Number:    discussion.Number,
+			Title:     xmlSafe(discussion.Title),
+			URL:       discussion.URL,
+			Author:    discussion.Author,
+			State:     discussion.State,
+			CreatedAt: discussion.CreatedAt,
+			Comments:  newXMLDiscussionComments(discussion.Comments),
+		}
+		if discussion.Body != "" {
+			doc.Discussion.Body = &xmlCDATA{Text: xmlSafe(discussion.Body)}
+		}
+		for _, thread := range discussion.Reviews {
+			doc.Discussion.Reviews = append(doc.Discussion.Reviews, xmlReviewThread{
+				Path:     thread.Path,
+				Line:     thread.Line,
+				DiffHunk: xmlCDATA{Text: xmlSafe(thread.DiffHunk)},
+				Comments: newXMLDiscussionComments(thread.Comments),
+			})
+		}
+	}
+	if changes := output.Changes; changes != nil {
+		doc.Changes = &xmlChanges{Base: changes.Base, BaseCommit: changes.BaseCommit, Head: changes.Head, HeadCommit: changes.HeadCommit}
+		for _, commit := range changes.Commits {
+			doc.Changes.Commits = append(doc.Changes.Commits, newXMLCommit(commit))
+		}
+		for _, file := range changes.Files {
+			doc.Changes.Files = append(doc.Changes.Files, xmlFileChange{Path: file.Path, Status: file.Status, From: file.From, Diff: xmlSafe(file.Diff)})
+		}
+	}
+	if len(output.History) > 0 {
+		doc.History = &xmlHistory{}
+		for _, commit := range output.History {
+			doc.History.Commits = append(doc.History.Commits, newXMLCommit(commit))
+		}
+	}
+	if output.DirectoryTree != "" {
+		doc.DirectoryTree = &xmlCDATA{Text: xmlSafe(output.DirectoryTree)}
+	}
+	if len(output.Images) > 0 {
+		doc.Images = &xmlImages{}
+		for _, image := range output.Images {
+			doc.Images.Images = append(doc.Images.Images, xmlImage{Path: image.Path, Link: image.Link, Size: image.Size})
+		}
+	}
+	for i, file := range output.Files {
+		var lastCommit *xmlCommit
+		if file.LastCommit != nil {
+			commit := newXMLCommit(*file.LastCommit)
+			lastCommit = &commit
+		}
+		document := xmlDocument{
+			Index:      i + 1,
+			Path:       file.Path,
+			Language:   file.Language,
+			Size:       file.Size,
+			Tokens:     file.Tokens,
+			Truncated:  file.Truncated,
+			Chunk:      file.Chunk,
+			Derived:    file.Derived,
+			Dependency: file.Dependency,
+			Outline:    file.Outline,
+			Source:     file.Path,
+			LastCommit: lastCommit,
+			Content:    xmlCDATA{Text: xmlSafe(file.Content)},
+		}
+		if file.Dependency != "" {
+			doc.Dependencies = append(doc.Dependencies, document)
+		} else {
+			doc.Documents = append(doc.Documents, document)
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode xml: %w", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func (xmlRenderer) Extension() string { return ".xml" }
+
+func newXMLDiscussionComments(comments []DiscussionComment) []xmlDiscussionComment {
+	var entries []xmlDiscussionComment
+	for _, comment := range comments {
+		entries = append(entries, xmlDiscussionComment{Author: comment.Author, Date: comment.Date, State: comment.State, Body: xmlSafe(comment.Body)})
+	}
+	return entries
+}
+
+func newXMLCommit(commit CommitInfo) xmlCommit {
+	entry := xmlCommit{Hash: commit.Hash, Author: xmlSafe(commit.Author), Date: commit.Date, Subject: xmlSafe(commit.Subject)}
+	if commit.Body != "" {
+		entry.Body = &xmlCDATA{Text: xmlSafe(commit.Body)}
+	}
+	return entry
+}
+
+// xmlSafe replaces characters that are not allowed anywhere in an XML
+// document, including CDATA sections. encoding/xml already splits "]]>".
+func xmlSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return r
+		case r < 0x20, r == 0xFFFE, r == 0xFFFF:
+			return utf8.RuneError
+		}
+		return r
+	}, s)
+}
+
+type textRenderer struct{}
+
+func (textRenderer) Render(w io.Writer, output *Output) error {
+	var sb strings.Builder
+	sb.WriteString("Source Code Context")
+	if output.Part != nil {
+		fmt.Fprintf(&sb, " (Part %d of %d)", output.Part.Index, output.Part.Count)
+	}
+	fmt.Fprintf(&sb, "\nGenerated on: %s\nTotal Files: %d\nTotal Size: %d bytes\nEstimated Tokens: ~%d\n",
+		output.GenerationDate, output.FileCount, output.TotalSize, output.TotalTokens)
+	if output.Part != nil {
+		sb.WriteString("\nParts:\n")
+		for _, summary := range output.Part.Parts {
+			fmt.Fprintf(&sb, "  %s: %s\n", summary.Name, summary.Summary)
+		}
+	}
+	if len(output.Omitted) > 0 {
+		sb.WriteString("\nOmitted Files:\n")
+		for _, entry := range output.Omitted {
+			fmt.Fprintf(&sb, "  %s (~%d tokens): %s\n", entry.Path, entry.Tokens, entry.Reason)
+		}
+	}
+	if discussion := output.Discussion; discussion != nil {
+		fmt.Fprintf(&sb, "\n%s #%d: %s\nURL: %s\nAuthor: %s\nState: %s\nCreated: %s\n",
+			discussion.Kind, discussion.Number, discussion.Title, discussion.URL, discussion.Author, discussion.State, discussion.CreatedAt)
+		if discussion.Body != "" {
+			fmt.Fprintf(&sb, "\n%s\n", discussion.Body)
+		}
+		for _, comment := range discussion.Comments {
+			writeTextComment(&sb, comment, 0)
+		}
+		for _, thread := range discussion.Reviews {
+			fmt.Fprintf(&sb, "\nReview thread on %s", thread.Path)
+			if thread.Line > 0 {
+				fmt.Fprintf(&sb, ":%d", thread.Line)
+			}
+			fmt.Fprintf(&sb, "\n%s\n", indent(2, thread.DiffHunk))
+			for _, comment := range thread.Comments {
+				writeTextComment(&sb, comment, 2)
+			}
+		}
+	}
+	if changes := output.Changes; changes != nil {
+		fmt.Fprintf(&sb, "\nChanges: %s (%s) to %s (%s), %d files changed\n",
+			changes.Base, changes.BaseCommit, changes.Head, changes.HeadCommit, len(changes.Files))
+		for _, commit := range changes.Commits {
+			fmt.Fprintf(&sb, "  %s %s (%s, %s)\n", commit.Hash, commit.Subject, commit.Author, commit.Date)
+			if commit.Body != "" {
+				fmt.Fprintf(&sb, "%s\n", indent(4, commit.Body))
+			}
+		}
+		for _, file := range changes.Files {
+			fmt.Fprintf(&sb, "\n%s", file.Diff)
+		}
+	}
+	if len(output.History) > 0 {
+		sb.WriteString("\nRecent History:\n")
+		for _, commit := range output.History {
+			fmt.Fprintf(&sb, "  %s %s (%s, %s)\n", commit.Hash, commit.Subject, commit.Author, commit.Date)
+		}
+	}
+	if output.DirectoryTree != "" {
+		sb.WriteString("\nDirectory Structure:\n")
+		sb.WriteString(output.DirectoryTree)
+	} else if output.Part != nil {
+		fmt.Fprintf(&sb, "\nDirectory Structure: see %s\n", output.Part.TreeFile)
+	}
+	if len(output.Images) > 0 {
+		sb.WriteString("\nImages:\n")
+		for _, image := range output.Images {
+			fmt.Fprintf(&sb, "  %s: %s\n", image.Path, image.Link)
+		}
+	}
+	separator := strings.Repeat("=", 80)
+	dependencies := false
+	for _, file := range output.Files {
+		if file.Dependency != "" && !dependencies {
+			dependencies = true
+			sb.WriteString("\nDependencies:\n")
+		}
+		fmt.Fprintf(&sb, "\n%s\nFile: %s", separator, file.Path)
+		if file.Derived != "" {
+			fmt.Fprintf(&sb, " (extracted from %s)", file.Derived)
+		}
+		if file.Outline {
+			sb.WriteString(" (outline)")
+		}
+		if file.Chunk != "" {
+			fmt.Fprintf(&sb, " (chunk %s)", file.Chunk)
+		}
+		if file.Truncated {
+			sb.WriteString(" (truncated)")
+		}
+		if commit := file.LastCommit; commit != nil {
+			fmt.Fprintf(&sb, "\nLast commit: %s %s (%s, %s)", commit.Hash, commit.Subject, commit.Author, commit.Date)
+		}
+		fmt.Fprintf(&sb, "\n%s\n%s\n", separator, strings.TrimSuffix(file.Content, "\n"))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (textRenderer) Extension() string { return ".txt" }
+
+func writeTextComment(sb *strings.Builder, comment DiscussionComment, depth int) {
+	fmt.Fprintf(sb, "\n%s%s (%s)", strings.Repeat(" ", depth), comment.Author, comment.Date)
+	if comment.State != "" {
+		fmt.Fprintf(sb, " [%s]", comment.State)
+	}
+	if comment.Body == "" {
+		sb.WriteString("\n")
+		return
+	}
+	fmt.Fprintf(sb, ":\n%s\n", indent(depth+2, comment.Body))
+}
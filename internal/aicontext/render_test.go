@@ -0,0 +1,40 @@
+package aicontext
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONLLeadingRecords(t *testing.T) {
+	output := &Output{
+		Discussion: &Discussion{Kind: "Issue", Number: 3, Title: "Crash on start"},
+		History:    []CommitInfo{{Hash: "abc1234", Subject: "Fix crash"}},
+		Files:      []FileEntry{{Path: "main.go", Content: "package main\n"}},
+	}
+	var buf bytes.Buffer
+	if err := renderers["jsonl"].Render(&buf, output); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d records, want 3:\n%s", len(lines), buf.String())
+	}
+	var discussion struct {
+		Discussion *Discussion `json:"discussion"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &discussion); err != nil || discussion.Discussion == nil || discussion.Discussion.Title != "Crash on start" {
+		t.Errorf("first record is not the discussion: %s", lines[0])
+	}
+	var history struct {
+		History []CommitInfo `json:"history"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &history); err != nil || len(history.History) != 1 || history.History[0].Subject != "Fix crash" {
+		t.Errorf("second record is not the history: %s", lines[1])
+	}
+	var file FileEntry
+	if err := json.Unmarshal([]byte(lines[2]), &file); err != nil || file.Path != "main.go" {
+		t.Errorf("third record is not the file: %s", lines[2])
+	}
+}
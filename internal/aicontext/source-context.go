@@ -2,63 +2,224 @@ package aicontext
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"text/template"
 	"time"
 
-	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/rs/zerolog/log"
 )
 
 type FileEntry struct {
-	Path     string
-	Content  string
-	Language string
+	Path      string `json:"path"`
+	Content   string `json:"content"`
+	Language  string `json:"language"`
+	Size      int64  `json:"size"`
+	Tokens    int    `json:"tokens"`
+	Truncated bool   `json:"truncated,omitempty"`
+	// Chunk is set to "i/n" when a file larger than the split limit is
+	// spread over several parts.
+	Chunk string `json:"chunk,omitempty"`
+	// LastCommit is the last commit that changed the file, set with
+	// --git-meta.
+	LastCommit *CommitInfo `json:"last_commit,omitempty"`
+	// Derived names the format a file was extracted from with
+	// --extract-docs, e.g. "pdf"; Content is then Markdown.
+	Derived string `json:"derived,omitempty"`
+	// Dependency is the module, with its version, that a file added with
+	// --with-deps belongs to. Dependency files follow the project's files.
+	Dependency string `json:"dependency,omitempty"`
+	// Outline is set when Content was reduced to declarations and
+	// signatures with --outline.
+	Outline bool `json:"outline,omitempty"`
 }
 
 type Output struct {
-	GenerationDate string
-	FileCount      int
-	TotalSize      int64
-	DirectoryTree  string
-	Files          []FileEntry
+	GenerationDate string         `json:"generation_date"`
+	FileCount      int            `json:"file_count"`
+	TotalSize      int64          `json:"total_size"`
+	TotalTokens    int            `json:"total_tokens"`
+	DirectoryTree  string         `json:"directory_tree,omitempty"`
+	Files          []FileEntry    `json:"files"`
+	Omitted        []OmittedEntry `json:"omitted,omitempty"`
+	Part           *PartInfo      `json:"part,omitempty"`
+	Changes        *ChangeSet     `json:"changes,omitempty"`
+	History        []CommitInfo   `json:"history,omitempty"`
+	Discussion     *Discussion    `json:"discussion,omitempty"`
+	Images         []ImageEntry   `json:"images,omitempty"`
 }
 
 type ProcessorConfig struct {
-	OutputPath   string
-	IncludeGlobs []string
-	ExcludeGlobs []string
-	MaxSize      int64
+	OutputPath string
+	// FixedOutputPath keeps OutputPath as given instead of switching its
+	// extension to match the output format.
+	FixedOutputPath bool
+	IncludeGlobs    []string
+	ExcludeGlobs    []string
+	MaxSize         int64
+	NoGitignore     bool
+	IncludeRegex    []*regexp.Regexp
+	ExcludeRegex    []*regexp.Regexp
+	Grep            []*regexp.Regexp
+	ExcludeGrep     []*regexp.Regexp
+	MaxTokens       int
+	HighPriority    []string
+	LowPriority     []string
+	SplitTokens     int
+	SplitBytes      int
+	Format          string
+	Template        string
+	// Ref is the branch, tag or commit cloned for repository URLs that do
+	// not name one themselves.
+	Ref string
+	// GitMeta annotates files with their last commit and GitHistory adds
+	// that many recent commits; both make clones keep their history.
+	GitMeta    bool
+	GitHistory int
+	// GitHubAPIURL is the REST API base for pull requests and issues,
+	// DefaultGitHubAPIURL when empty.
+	GitHubAPIURL string
+	// UserAgent, WebTimeout and CrawlDepth configure web page sources;
+	// CrawlDepth is how many levels of same-site links are followed.
+	UserAgent  string
+	WebTimeout time.Duration
+	CrawlDepth int
+	// CaptionLanguage is the language of YouTube transcripts,
+	// DefaultCaptionLanguage when empty.
+	CaptionLanguage string
+	// Images is one of ImageModes: web page images are dropped, linked or
+	// downloaded, and "download" also copies the images of directories.
+	Images string
+	// ExtractDocs converts PDF, DOCX and notebook files to Markdown, with
+	// notebook cell outputs kept when NotebookOutputs is set.
+	ExtractDocs     bool
+	NotebookOutputs bool
+	// GoProxyURL, NPMRegistryURL and PyPIURL are the bases package sources
+	// are downloaded from, defaulting to GOPROXY or the public registries.
+	// GoModFile resolves the versions of go: sources that name none.
+	GoProxyURL     string
+	NPMRegistryURL string
+	PyPIURL        string
+	GoModFile      string
+	// Outline reduces source files with an outline transformer, such as
+	// Go files, to their declarations and signatures.
+	Outline bool
+	// WithDeps selects the Go modules required by go.mod whose sources are
+	// added from the module cache.
+	WithDeps []string
+	// NoCache clones into a temporary directory instead of reusing the
+	// checkout cache.
+	NoCache bool
+	// FlagsSet records which CLI flags were given explicitly, so that
+	// .aicontext.yaml and the user config only fill in the others.
+	FlagsSet map[string]bool
 }
 
 type Processor struct {
-	config ProcessorConfig
-	filter *PathFilter
+	config   ProcessorConfig
+	filter   *PathFilter
+	template *template.Template
+	// onlyPaths, changes and headTree are set in diff mode, where files are
+	// read from HEAD's tree so that they match the change set.
+	onlyPaths map[string]bool
+	changes   *ChangeSet
+	headTree  *object.Tree
+	// github replaces the REST client for pull requests and issues.
+	github githubAPI
+	// transport replaces the HTTP transport of web page and YouTube
+	// requests.
+	transport http.RoundTripper
+	// imageStore is created on the first image saved with --images
+	// download.
+	imageStore *imageStore
 }
 
-const markdownTemplate = `# Source Code Context
+const markdownTemplate = `# Source Code Context{{if .Part}} (Part {{.Part.Index}} of {{.Part.Count}}){{end}}
 
 Generated on: {{.GenerationDate}}
 
 ## Repository Overview
 - Total Files: {{.FileCount}}
 - Total Size: {{.TotalSize}} bytes
+- Estimated Tokens: ~{{.TotalTokens}}
+{{if .Part}}
+## Parts
 
+{{range .Part.Parts}}- {{.Name}}: {{.Summary}}
+{{end}}{{end}}{{if .Omitted}}
+## Omitted Files
+
+{{range .Omitted}}- {{.Path}} (~{{.Tokens}} tokens): {{.Reason}}
+{{end}}{{end}}{{with .Discussion}}
+## {{.Kind}} #{{.Number}}: {{.Title}}
+
+- URL: {{.URL}}
+- Author: {{.Author}}
+- State: {{.State}}
+- Created: {{.CreatedAt}}
+{{if .Body}}
+{{.Body}}
+{{end}}{{if .Comments}}
+### Comments
+{{range .Comments}}
+#### {{.Author}} ({{.Date}}){{if .State}} [{{.State}}]{{end}}
+{{if .Body}}
+{{.Body}}
+{{end}}{{end}}{{end}}{{if .Reviews}}
+### Review Threads
+{{range .Reviews}}
+#### {{.Path}}{{if .Line}}:{{.Line}}{{end}}
+
+{{$fence := fence .DiffHunk}}{{$fence}}diff
+{{.DiffHunk}}
+{{$fence}}
+{{range .Comments}}
+- {{.Author}} ({{.Date}}):
+{{indent 2 .Body}}
+{{end}}{{end}}{{end}}{{end}}{{with .Changes}}
+## Changes
+
+Comparing {{.Base}} ({{.BaseCommit}}) with {{.Head}} ({{.HeadCommit}}): {{len .Files}} files changed
+{{if .Commits}}
+### Commits
+
+{{range .Commits}}- {{.Hash}} {{.Subject}} ({{.Author}}, {{.Date}})
+{{if .Body}}{{indent 2 .Body}}
+{{end}}{{end}}{{end}}{{range .Files}}
+### Diff: {{.Path}} ({{.Status}}{{if .From}} from {{.From}}{{end}})
+
+{{$fence := fence .Diff}}{{$fence}}diff
+{{.Diff}}{{$fence}}
+{{end}}{{end}}{{if .History}}
+## Recent History
+
+{{range .History}}- {{.Hash}} {{.Subject}} ({{.Author}}, {{.Date}})
+{{end}}{{end}}
 ## Directory Structure
-` + "```" + `
+{{if .DirectoryTree}}{{$fence := fence .DirectoryTree}}{{$fence}}
 {{.DirectoryTree}}
-` + "```" + `
+{{$fence}}
+{{else if .Part}}See {{.Part.TreeFile}}.
+{{end}}{{if .Images}}
+## Images
 
+{{range .Images}}- {{.Path}}: ![{{.Path}}]({{.Link}})
+{{end}}{{end}}
 ## File Contents
-
-{{range .Files}}
-### File: {{.Path}}
-
-` + "```{{.Language}}" + `
+{{$dependencies := false}}{{range .Files}}{{if and .Dependency (not $dependencies)}}{{$dependencies = true}}
+## Dependencies
+{{end}}
+### File: {{.Path}}{{if .Derived}} (extracted from {{.Derived}}){{end}}{{if .Outline}} (outline){{end}}{{if .Chunk}} (chunk {{.Chunk}}){{end}}{{if .Truncated}} (truncated){{end}}
+{{with .LastCommit}}
+Last commit: {{.Hash}} {{.Subject}} ({{.Author}}, {{.Date}})
+{{end}}
+{{$fence := fence .Content}}{{$fence}}{{.Language}}
 {{.Content}}
-` + "```" + `
+{{$fence}}
 
 
 {{end}}`
@@ -66,40 +227,74 @@ Generated on: {{.GenerationDate}}
 func NewProcessor(config ProcessorConfig) *Processor {
 	return &Processor{
 		config: config,
-		filter: newPathFilter(config.IncludeGlobs, config.ExcludeGlobs),
+		filter: newPathFilter(config),
 	}
 }
 
 func (p *Processor) ProcessDirectory(path string) error {
-	output, err := p.processDirectory(path)
+	configRoot := path
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		configRoot = filepath.Dir(path)
+	}
+	return p.processPath(configRoot, path)
+}
+
+// processPath processes root, which may be a single file, with config files
+// read from configRoot. They differ when only a sub-path of a cloned
+// repository is processed.
+func (p *Processor) processPath(configRoot string, root string) error {
+	if err := p.applyConfigFiles(configRoot); err != nil {
+		return err
+	}
+	p.filter.loadRepoIgnores(configRoot)
+	p.filter.loadParentIgnores(configRoot, root)
+	if p.onlyPaths != nil {
+		p.filter.restrictTo(p.onlyPaths)
+	}
+	output, err := p.processDirectory(root)
 	if err != nil {
 		return fmt.Errorf("failed to process directory: %w", err)
 	}
+	output.Changes = p.changes
+	if err := p.addGitMeta(root, output); err != nil {
+		return fmt.Errorf("failed to read git metadata: %w", err)
+	}
+	if len(p.config.WithDeps) > 0 {
+		if err := p.addDependencies(configRoot, root, output); err != nil {
+			return fmt.Errorf("failed to add dependencies: %w", err)
+		}
+	}
+	if err := p.applyTokenBudget(output); err != nil {
+		return fmt.Errorf("failed to apply token budget: %w", err)
+	}
 	return p.writeOutput(output)
 }
 
-func (p *Processor) ProcessGitHubURL(url string) error {
-	tempDir, err := os.MkdirTemp("", "aicontext-clone-")
+// ProcessGitURL clones a repository from any supported remote and processes
+// the ref and sub-path named by the URL.
+func (p *Processor) ProcessGitURL(url string) error {
+	source, err := parseGitURL(url)
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+		return err
 	}
-	defer os.RemoveAll(tempDir)
-	cloneOpts := &git.CloneOptions{
-		URL:      url,
-		Progress: nil,
-		Depth:    1,
+	defer source.close()
+	if source.ref == "" && len(source.refPath) == 0 {
+		source.ref = p.config.Ref
 	}
-	if token := os.Getenv("GH_TOKEN"); token != "" {
-		cloneOpts.Auth = &http.BasicAuth{
-			Username: "git", // can be anything but not empty
-			Password: token,
-		}
+	source.fullHistory = p.config.wantsHistory()
+	if err := source.withAuth(source.resolveRefPath); err != nil {
+		return err
 	}
-	_, err = git.PlainClone(tempDir, false, cloneOpts)
+	dir, done, err := source.checkout(!p.config.NoCache)
 	if err != nil {
-		return fmt.Errorf("failed to clone repository: %w", err)
+		return err
+	}
+	defer done()
+	root := filepath.Join(dir, filepath.FromSlash(source.subPath))
+	if _, err := os.Stat(root); err != nil {
+		return fmt.Errorf("path %q not found in repository", source.subPath)
 	}
-	return p.ProcessDirectory(tempDir)
+	return p.processPath(dir, root)
 }
 
 func (p *Processor) processDirectory(root string) (*Output, error) {
@@ -107,12 +302,11 @@ func (p *Processor) processDirectory(root string) (*Output, error) {
 		GenerationDate: time.Now().Format(time.RFC3339),
 		Files:          make([]FileEntry, 0),
 	}
-	var totalSize int64
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		relPath, err := filepath.Rel(root, path)
+		relPath, err := relativePath(root, path, info)
 		if err != nil {
 			return err
 		}
@@ -123,49 +317,91 @@ func (p *Processor) processDirectory(root string) (*Output, error) {
 			return nil
 		}
 		if info.IsDir() {
+			p.filter.loadGitignore(root, relPath)
 			return nil
 		}
 		if p.config.MaxSize > 0 && info.Size() > p.config.MaxSize {
 			return nil
 		}
-		content, err := os.ReadFile(path)
-		if err != nil {
+		content, ok, err := p.readContent(path, relPath)
+		if err != nil || !ok {
 			return err
 		}
+		if p.filter.keepImages && isImageFile(relPath) {
+			return p.copyImage(relPath, content, output)
+		}
+		if entry, ok, err := p.extractDocument(relPath, content); ok {
+			if err != nil {
+				log.Debug().Str("package", "aicontext").Str("path", relPath).Err(err).Msg("failed to extract document")
+				return nil
+			}
+			if p.filter.shouldIncludeContent([]byte(entry.Content)) {
+				output.Files = append(output.Files, entry)
+			}
+			return nil
+		}
 		if isBinary(content) {
 			return nil
 		}
-		totalSize += info.Size()
-		output.Files = append(output.Files, FileEntry{
+		if !p.filter.shouldIncludeContent(content) {
+			return nil
+		}
+		entry := FileEntry{
 			Path:     relPath,
 			Content:  string(content),
 			Language: detectLanguage(relPath),
-		})
+			Size:     int64(len(content)),
+		}
+		p.transformContent(&entry)
+		entry.Tokens = countTokens(entry.Content)
+		output.Files = append(output.Files, entry)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	output.FileCount = len(output.Files)
-	output.TotalSize = totalSize
+	output.updateTotals()
 	output.DirectoryTree = p.generateDirectoryTree(root)
 	return output, nil
 }
 
-func (p *Processor) writeOutput(output *Output) error {
-	tmpl, err := template.New("markdown").Parse(markdownTemplate)
+// readContent reads a walked file from the working tree, or in diff mode
+// from HEAD's tree. Files that HEAD does not have, such as untracked
+// neighbours, are skipped with ok false.
+func (p *Processor) readContent(path string, relPath string) ([]byte, bool, error) {
+	if p.headTree == nil {
+		content, err := os.ReadFile(path)
+		return content, err == nil, err
+	}
+	file, err := p.headTree.File(filepath.ToSlash(relPath))
 	if err != nil {
-		return fmt.Errorf("failed to parse template: %w", err)
+		return nil, false, nil
+	}
+	if p.config.MaxSize > 0 && file.Size > p.config.MaxSize {
+		return nil, false, nil
 	}
-	file, err := os.Create(p.config.OutputPath)
+	contents, err := file.Contents()
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return nil, false, fmt.Errorf("failed to read %s from HEAD: %w", relPath, err)
 	}
-	defer file.Close()
-	if err := tmpl.Execute(file, output); err != nil {
-		return fmt.Errorf("failed to execute template: %w", err)
+	return []byte(contents), true, nil
+}
+
+func (o *Output) updateTotals() {
+	o.FileCount = len(o.Files)
+	o.TotalSize = 0
+	o.TotalTokens = 0
+	for _, file := range o.Files {
+		o.TotalSize += file.Size
+		o.TotalTokens += file.Tokens
+	}
+}
+
+func (p *Processor) writeOutput(output *Output) error {
+	if p.config.SplitTokens > 0 || p.config.SplitBytes > 0 {
+		return p.writeParts(output)
 	}
-	return nil
+	return p.writeSingle(p.outputPath(), output)
 }
 
 func detectLanguage(path string) string {
@@ -222,7 +458,7 @@ func (p *Processor) generateDirectoryTree(root string) string {
 		if err != nil {
 			return err
 		}
-		relPath, err := filepath.Rel(root, path)
+		relPath, err := relativePath(root, path, info)
 		if err != nil {
 			return err
 		}
@@ -232,6 +468,9 @@ func (p *Processor) generateDirectoryTree(root string) string {
 			}
 			return nil
 		}
+		if info.IsDir() {
+			p.filter.loadGitignore(root, relPath)
+		}
 		if relPath == "." {
 			return nil
 		}
@@ -249,6 +488,15 @@ func (p *Processor) generateDirectoryTree(root string) string {
 	return tree.String()
 }
 
+// relativePath is path relative to root, or the file name when root is a
+// single file rather than a directory.
+func relativePath(root string, path string, info os.FileInfo) (string, error) {
+	if path == root && !info.IsDir() {
+		return info.Name(), nil
+	}
+	return filepath.Rel(root, path)
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
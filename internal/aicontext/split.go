@@ -0,0 +1,290 @@
+package aicontext
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PartInfo describes one file of a split output. Parts share the directory
+// tree, which is only rendered into the first part.
+type PartInfo struct {
+	Index    int           `json:"index"`
+	Count    int           `json:"count"`
+	TreeFile string        `json:"tree_file"`
+	Parts    []PartSummary `json:"parts"`
+}
+
+type PartSummary struct {
+	Name    string `json:"name"`
+	Summary string `json:"summary"`
+}
+
+// partFileName turns context/gh-repo.md into context/gh-repo.part-001.md.
+func partFileName(outputPath string, index int) string {
+	ext := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s.part-%03d%s", strings.TrimSuffix(outputPath, ext), index, ext)
+}
+
+// splitMeasure returns the size function and limit selected by
+// --split-tokens or --split-bytes.
+func (p *Processor) splitMeasure() (func(string) int, int) {
+	if p.config.SplitTokens > 0 {
+		return countTokens, p.config.SplitTokens
+	}
+	return func(s string) int { return len(s) }, p.config.SplitBytes
+}
+
+// writeParts spreads the files of output over as many part files as needed
+// to keep each under the split limit.
+func (p *Processor) writeParts(output *Output) error {
+	measure, limit := p.splitMeasure()
+	outputPath := p.outputPath()
+	if outputPath == StdoutOutput {
+		return fmt.Errorf("split output cannot be written to stdout")
+	}
+
+	var header strings.Builder
+	if err := p.renderer().Render(&header, &Output{
+		GenerationDate: output.GenerationDate,
+		FileCount:      output.FileCount,
+		TotalSize:      output.TotalSize,
+		TotalTokens:    output.TotalTokens,
+		Part:           &PartInfo{Index: 1, Count: 1, TreeFile: filepath.Base(outputPath)},
+	}); err != nil {
+		return err
+	}
+	// Leave room for the part index, which grows with the number of parts.
+	available := max(limit-measure(header.String())-measure(strings.Repeat("x", 80)), 1)
+	firstAvailable := max(available-measure(output.DirectoryTree)-measure(renderOmitted(output.Omitted))-p.sectionsMeasure(output, measure), 1)
+
+	// Every part lists all parts, which takes more room the more parts
+	// there are, so the files are laid out again until the list fits.
+	var parts [][]FileEntry
+	var summaries []PartSummary
+	reserved := 0
+	for {
+		parts = p.layoutParts(output.Files, max(firstAvailable-reserved, 1), max(available-reserved, 1), measure)
+		if len(parts) == 1 {
+			return p.writeSingle(outputPath, output)
+		}
+		summaries = make([]PartSummary, len(parts))
+		for i, files := range parts {
+			summaries[i] = PartSummary{
+				Name:    filepath.Base(partFileName(outputPath, i+1)),
+				Summary: summarizePart(files, i == 0),
+			}
+		}
+		// A list that leaves no room for files cannot be made to fit.
+		needed := p.partListMeasure(summaries, measure)
+		if needed <= reserved || needed >= available/2 {
+			break
+		}
+		reserved = needed
+	}
+
+	for i, files := range parts {
+		part := &Output{
+			GenerationDate: output.GenerationDate,
+			FileCount:      output.FileCount,
+			TotalSize:      output.TotalSize,
+			TotalTokens:    output.TotalTokens,
+			Files:          files,
+			Part: &PartInfo{
+				Index:    i + 1,
+				Count:    len(parts),
+				TreeFile: summaries[0].Name,
+				Parts:    summaries,
+			},
+		}
+		if i == 0 {
+			part.DirectoryTree = output.DirectoryTree
+			part.Omitted = output.Omitted
+			part.Changes = output.Changes
+			part.History = output.History
+			part.Discussion = output.Discussion
+			part.Images = output.Images
+		}
+		if err := p.writeSingle(partFileName(outputPath, i+1), part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// layoutParts groups files into parts, the first holding up to
+// firstCapacity and the others up to capacity. Files are only split across
+// parts (by lines) when a single file does not fit into an empty part.
+func (p *Processor) layoutParts(files []FileEntry, firstCapacity int, capacity int, measure func(string) int) [][]FileEntry {
+	var parts [][]FileEntry
+	var current []FileEntry
+	used := 0
+	partCapacity := firstCapacity
+	flush := func() {
+		parts = append(parts, current)
+		current = nil
+		used = 0
+		partCapacity = capacity
+	}
+	for _, file := range files {
+		size := p.fileBlockMeasure(file, measure)
+		if used+size > partCapacity && len(current) > 0 {
+			flush()
+		}
+		if size <= partCapacity {
+			current = append(current, file)
+			used += size
+			continue
+		}
+		chunks := p.chunkFile(file, partCapacity, capacity, measure)
+		for i, chunk := range chunks {
+			if i > 0 {
+				flush()
+			}
+			current = append(current, chunk)
+			used += p.fileBlockMeasure(chunk, measure)
+		}
+	}
+	if len(current) > 0 || len(parts) == 0 {
+		flush()
+	}
+	return parts
+}
+
+// partListMeasure is what the list of all parts adds to each part.
+func (p *Processor) partListMeasure(summaries []PartSummary, measure func(string) int) int {
+	var without, with strings.Builder
+	renderer := p.renderer()
+	info := PartInfo{Index: 1, Count: len(summaries), TreeFile: summaries[0].Name}
+	if renderer.Render(&without, &Output{Part: &info}) != nil {
+		return 0
+	}
+	info.Parts = summaries
+	if renderer.Render(&with, &Output{Part: &info}) != nil {
+		return 0
+	}
+	return measure(with.String()) - measure(without.String())
+}
+
+func (p *Processor) writeSingle(path string, output *Output) error {
+	if path == StdoutOutput {
+		return p.writeStdout(output)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer file.Close()
+	return p.renderer().Render(file, output)
+}
+
+// fileBlockMeasure is what a file adds to a part in the selected format.
+func (p *Processor) fileBlockMeasure(file FileEntry, measure func(string) int) int {
+	var empty, single strings.Builder
+	renderer := p.renderer()
+	if renderer.Render(&empty, &Output{}) != nil || renderer.Render(&single, &Output{Files: []FileEntry{file}}) != nil {
+		return measure(file.Content)
+	}
+	return measure(single.String()) - measure(empty.String())
+}
+
+// sectionsMeasure is what the Discussion, Changes, Recent History and
+// Images sections add to the first part.
+func (p *Processor) sectionsMeasure(output *Output, measure func(string) int) int {
+	if output.Discussion == nil && output.Changes == nil && len(output.History) == 0 && len(output.Images) == 0 {
+		return 0
+	}
+	var empty, section strings.Builder
+	renderer := p.renderer()
+	sections := &Output{Discussion: output.Discussion, Changes: output.Changes, History: output.History, Images: output.Images}
+	if renderer.Render(&empty, &Output{}) != nil || renderer.Render(&section, sections) != nil {
+		return 0
+	}
+	return measure(section.String()) - measure(empty.String())
+}
+
+func renderOmitted(omitted []OmittedEntry) string {
+	var sb strings.Builder
+	for _, entry := range omitted {
+		fmt.Fprintf(&sb, "- %s (~%d tokens): %s\n", entry.Path, entry.Tokens, entry.Reason)
+	}
+	return sb.String()
+}
+
+// chunkFile splits a file by lines so that the first chunk fits in
+// firstCapacity and every following chunk in capacity.
+func (p *Processor) chunkFile(file FileEntry, firstCapacity int, capacity int, measure func(string) int) []FileEntry {
+	overhead := p.fileBlockMeasure(FileEntry{Path: file.Path, Language: file.Language, Derived: file.Derived, Outline: file.Outline, Chunk: "000/000"}, measure)
+	// Lines are measured as they are, so scale the limits by how much the
+	// format grows the content, such as by escaping it in JSON or XML.
+	raw, rendered := measure(file.Content), p.fileBlockMeasure(file, measure)-overhead
+	scale := func(capacity int) int {
+		if raw > 0 && rendered > raw {
+			return capacity * raw / rendered
+		}
+		return capacity
+	}
+	var chunks []FileEntry
+	var sb strings.Builder
+	limit := max(scale(firstCapacity-overhead), 1)
+	used := 0
+	for _, line := range strings.SplitAfter(file.Content, "\n") {
+		size := measure(line)
+		if used+size > limit && sb.Len() > 0 {
+			chunks = append(chunks, newChunk(file, sb.String()))
+			sb.Reset()
+			used = 0
+			limit = max(scale(capacity-overhead), 1)
+		}
+		sb.WriteString(line)
+		used += size
+	}
+	if sb.Len() > 0 {
+		chunks = append(chunks, newChunk(file, sb.String()))
+	}
+	for i := range chunks {
+		chunks[i].Chunk = fmt.Sprintf("%d/%d", i+1, len(chunks))
+	}
+	return chunks
+}
+
+func newChunk(file FileEntry, content string) FileEntry {
+	return FileEntry{
+		Path:       file.Path,
+		Content:    content,
+		Language:   file.Language,
+		Size:       int64(len(content)),
+		Tokens:     countTokens(content),
+		Truncated:  file.Truncated,
+		LastCommit: file.LastCommit,
+		Derived:    file.Derived,
+		Dependency: file.Dependency,
+		Outline:    file.Outline,
+	}
+}
+
+func summarizePart(files []FileEntry, hasTree bool) string {
+	var summary string
+	if hasTree {
+		summary = "directory structure, "
+	}
+	if len(files) == 0 {
+		return strings.TrimSuffix(summary, ", ")
+	}
+	first, last := files[0], files[len(files)-1]
+	firstName, lastName := first.Path, last.Path
+	if first.Chunk != "" {
+		firstName += " (chunk " + first.Chunk + ")"
+	}
+	if last.Chunk != "" {
+		lastName += " (chunk " + last.Chunk + ")"
+	}
+	if len(files) == 1 {
+		return summary + firstName
+	}
+	return summary + fmt.Sprintf("%d files, %s to %s", len(files), firstName, lastName)
+}
@@ -0,0 +1,135 @@
+package aicontext
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// splitFile is a file of the given number of 20-byte lines.
+func splitFile(path string, lines int) FileEntry {
+	var content strings.Builder
+	for i := range lines {
+		fmt.Fprintf(&content, "%-19s\n", fmt.Sprintf("%s:%d", path, i))
+	}
+	return FileEntry{Path: path, Content: content.String(), Size: int64(content.Len()), Tokens: countTokens(content.String())}
+}
+
+// readParts reads the parts written for outputPath and describes each as
+// its files, with chunks as path[i/n].
+func readParts(t *testing.T, outputPath string) ([]*Output, []string) {
+	t.Helper()
+	var parts []*Output
+	var layout []string
+	for i := 1; ; i++ {
+		data, err := os.ReadFile(partFileName(outputPath, i))
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		part := &Output{}
+		if err := json.Unmarshal(data, part); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, file := range part.Files {
+			name := file.Path
+			if file.Chunk != "" {
+				name += "[" + file.Chunk + "]"
+			}
+			names = append(names, name)
+		}
+		parts = append(parts, part)
+		layout = append(layout, strings.Join(names, " "))
+	}
+	return parts, layout
+}
+
+func TestWriteParts(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []FileEntry
+		tree       string
+		splitBytes int
+		wantLayout []string
+	}{
+		{
+			name:       "single part",
+			files:      []FileEntry{splitFile("a.go", 5), splitFile("b.go", 5)},
+			splitBytes: 8000,
+			wantLayout: nil,
+		},
+		{
+			name:       "files packed in order",
+			files:      []FileEntry{splitFile("a.go", 100), splitFile("b.go", 100), splitFile("c.go", 100), splitFile("d.go", 100)},
+			splitBytes: 6000,
+			wantLayout: []string{"a.go b.go", "c.go d.go"},
+		},
+		{
+			name:       "tree takes room in the first part",
+			files:      []FileEntry{splitFile("a.go", 100), splitFile("b.go", 100), splitFile("c.go", 100), splitFile("d.go", 100)},
+			tree:       strings.Repeat("some/dir/file.go\n", 120),
+			splitBytes: 6000,
+			wantLayout: []string{"a.go", "b.go c.go", "d.go"},
+		},
+		{
+			name:       "oversized file split by lines",
+			files:      []FileEntry{splitFile("a.go", 50), splitFile("big.go", 600), splitFile("z.go", 50)},
+			splitBytes: 6000,
+			// The oversized file starts a new part rather than filling the
+			// rest of the previous one.
+			wantLayout: []string{"a.go", "big.go[1/3]", "big.go[2/3]", "big.go[3/3] z.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputPath := filepath.Join(t.TempDir(), "context.json")
+			p := NewProcessor(ProcessorConfig{Format: "json", OutputPath: outputPath, FixedOutputPath: true, SplitBytes: tt.splitBytes})
+			output := &Output{GenerationDate: "2024-01-01T00:00:00Z", DirectoryTree: "a.go\nb.go\n" + tt.tree, Files: tt.files}
+			output.updateTotals()
+			if err := p.writeParts(output); err != nil {
+				t.Fatal(err)
+			}
+			parts, layout := readParts(t, outputPath)
+			if strings.Join(layout, " | ") != strings.Join(tt.wantLayout, " | ") {
+				t.Fatalf("got parts %q, want %q", layout, tt.wantLayout)
+			}
+			if len(parts) == 0 {
+				if _, err := os.Stat(outputPath); err != nil {
+					t.Errorf("single output not written: %v", err)
+				}
+				return
+			}
+
+			contents := make(map[string]string)
+			for i, part := range parts {
+				info, err := os.Stat(partFileName(outputPath, i+1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() > int64(tt.splitBytes) {
+					t.Errorf("part %d has %d bytes, over the limit of %d", i+1, info.Size(), tt.splitBytes)
+				}
+				if part.Part == nil || part.Part.Index != i+1 || part.Part.Count != len(parts) {
+					t.Errorf("part %d has part info %+v", i+1, part.Part)
+				}
+				if (part.DirectoryTree != "") != (i == 0) {
+					t.Errorf("part %d has directory tree %q", i+1, part.DirectoryTree)
+				}
+				for _, file := range part.Files {
+					contents[file.Path] += file.Content
+				}
+			}
+			// Chunks add up to the whole file.
+			for _, file := range tt.files {
+				if contents[file.Path] != file.Content {
+					t.Errorf("%s not reassembled from its parts", file.Path)
+				}
+			}
+		})
+	}
+}
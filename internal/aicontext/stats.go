@@ -64,6 +64,12 @@ func countWords(text string) int {
 }
 
 func estimateTokens(text string, chars int) int {
+	return int(math.Round(tokenEstimate(text, chars)))
+}
+
+// tokenEstimate is the unrounded estimate, which adds up across pieces of a
+// text without drifting from the estimate of the whole.
+func tokenEstimate(text string, chars int) float64 {
 	digits := 0
 	separators := 0
 	for _, r := range text {
@@ -80,8 +86,7 @@ func estimateTokens(text string, chars int) int {
 	// 0.26 * chars + 0.65 * digits + 0.25 * separators
 	// This has an average absolute error of ~2% against cl100k (GPT-4) and o200k (GPT-4o) tokenizers
 	// across prose, code, and configuration files.
-	estimate := 0.26*float64(chars) + 0.65*float64(digits) + 0.25*float64(separators)
-	return int(math.Round(estimate))
+	return 0.26*float64(chars) + 0.65*float64(digits) + 0.25*float64(separators)
 }
 
 func humanizeBytes(bytes int64) string {
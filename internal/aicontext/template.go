@@ -0,0 +1,127 @@
+package aicontext
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are available to the built-in template and to templates
+// given with --template:
+//
+//	tokens s       estimated token count of s
+//	numberLines s  s with right-aligned line numbers prepended
+//	fence s        a code fence that no line of s can close
+//	base p, dir p  last element and parent of a slash-separated path
+//	ext p          extension of p, including the dot
+//	humanize n     byte count as B/KB/MB/GB
+//	indent n s     s with every line indented by n spaces
+//
+// Templates are executed with *Output as data; see Output, FileEntry,
+// OmittedEntry and PartInfo for the available fields.
+var templateFuncs = template.FuncMap{
+	"tokens":      countTokens,
+	"numberLines": numberLines,
+	"fence":       fenceFor,
+	"base":        func(p string) string { return path.Base(filepath.ToSlash(p)) },
+	"dir":         func(p string) string { return path.Dir(filepath.ToSlash(p)) },
+	"ext":         func(p string) string { return path.Ext(filepath.ToSlash(p)) },
+	"humanize":    humanizeBytes,
+	"indent":      indent,
+}
+
+var builtinTemplate = template.Must(template.New("markdown").Funcs(templateFuncs).Parse(markdownTemplate))
+
+// BuiltinTemplate returns the source of the default markdown template, as a
+// starting point for --template.
+func BuiltinTemplate() string {
+	return markdownTemplate
+}
+
+func executeTemplate(w io.Writer, tmpl *template.Template, output *Output) error {
+	if err := tmpl.Execute(w, output); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+	return nil
+}
+
+// loadTemplate parses a user template file with templateFuncs registered.
+func loadTemplate(templatePath string) (*template.Template, error) {
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	tmpl, err := template.New(filepath.Base(templatePath)).Funcs(templateFuncs).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// templateRenderer renders a user template. The output extension is taken
+// from the template name, so context.xml.tmpl produces .xml files.
+type templateRenderer struct {
+	tmpl *template.Template
+	ext  string
+}
+
+func newTemplateRenderer(tmpl *template.Template) templateRenderer {
+	ext := filepath.Ext(strings.TrimSuffix(tmpl.Name(), ".tmpl"))
+	if ext == "" {
+		ext = ".md"
+	}
+	return templateRenderer{tmpl: tmpl, ext: ext}
+}
+
+func (r templateRenderer) Render(w io.Writer, output *Output) error {
+	return executeTemplate(w, r.tmpl, output)
+}
+
+func (r templateRenderer) Extension() string { return r.ext }
+
+func numberLines(content string) string {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	width := len(fmt.Sprint(len(lines)))
+	var sb strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&sb, "%*d  %s\n", width, i+1, line)
+	}
+	return sb.String()
+}
+
+func indent(n int, content string) string {
+	prefix := strings.Repeat(" ", n)
+	return prefix + strings.ReplaceAll(content, "\n", "\n"+prefix)
+}
+
+// fenceFor returns a code fence that no line of content can close. Content
+// without a run of three backticks keeps the usual ```; otherwise ~~~ is used
+// when the content has no run of three tildes, and a backtick fence longer
+// than the longest backtick run when it has both.
+func fenceFor(content string) string {
+	backticks := longestRun(content, '`')
+	if backticks < 3 {
+		return "```"
+	}
+	if longestRun(content, '~') < 3 {
+		return "~~~"
+	}
+	return strings.Repeat("`", backticks+1)
+}
+
+func longestRun(content string, char rune) int {
+	longest, run := 0, 0
+	for _, r := range content {
+		if r == char {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
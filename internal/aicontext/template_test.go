@@ -0,0 +1,139 @@
+package aicontext
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// fencedBlocks returns the contents of the fenced code blocks in a Markdown
+// document, closing each block as CommonMark does: on a line indented by at
+// most three spaces with a run of the opening character at least as long as
+// the opening fence and nothing else.
+func fencedBlocks(markdown string) []string {
+	var blocks, lines []string
+	var fenceChar byte
+	fenceLen := 0
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		indented := len(line)-len(trimmed) > 3
+		if fenceLen == 0 {
+			if !indented && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")) {
+				fenceChar = trimmed[0]
+				fenceLen = len(trimmed) - len(strings.TrimLeft(trimmed, string(fenceChar)))
+				lines = nil
+			}
+			continue
+		}
+		run := len(trimmed) - len(strings.TrimLeft(trimmed, string(fenceChar)))
+		if !indented && run >= fenceLen && strings.TrimSpace(trimmed[run:]) == "" {
+			blocks = append(blocks, strings.Join(lines, "\n"))
+			fenceLen = 0
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return blocks
+}
+
+func TestRenderersDelimitContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"plain", "package main\n"},
+		{"backtick fence", "# Notes\n\n```go\nfunc main() {}\n```\n"},
+		{"long backtick fence", "````markdown\n```go\nx\n```\n````\n"},
+		{"tilde fence", "~~~\ncode\n~~~\n"},
+		{"backticks and tildes", "```\na\n```\n~~~~\nb\n~~~~\n`````\n"},
+		{"indented fence", "text\n   ```\n  ~~~\n"},
+		{"fence on last line", "first\n```"},
+		{"tilde fence on last line", "first\n```go\n~~~"},
+	}
+	for _, tt := range tests {
+		output := &Output{
+			GenerationDate: "2024-01-01 00:00:00",
+			FileCount:      2,
+			DirectoryTree:  "├── " + "```" + ".md\n└── ~~~~\n",
+			Files: []FileEntry{
+				{Path: "a.md", Language: "markdown", Content: tt.content},
+				{Path: "b.go", Language: "go", Content: "package b\n"},
+			},
+		}
+		for _, format := range OutputFormats {
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := renderers[format].Render(&buf, output); err != nil {
+					t.Fatalf("render failed: %v", err)
+				}
+				got := contentsOf(t, format, buf.String())
+				want := []string{output.Files[0].Content, output.Files[1].Content}
+				if format == "markdown" {
+					// Code blocks hold the directory tree first, and the
+					// template ends every block with a newline.
+					want = append([]string{output.DirectoryTree}, want...)
+					for i := range want {
+						want[i] = strings.TrimSuffix(want[i], "\n")
+					}
+				}
+				if len(got) != len(want) {
+					t.Fatalf("got %d delimited sections, want %d:\n%s", len(got), len(want), buf.String())
+				}
+				for i := range want {
+					if strings.TrimSuffix(got[i], "\n") != strings.TrimSuffix(want[i], "\n") {
+						t.Errorf("section %d = %q, want %q", i, got[i], want[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+// contentsOf parses a rendered output back into the file contents it
+// delimits, and for markdown into all of its code blocks.
+func contentsOf(t *testing.T, format string, rendered string) []string {
+	t.Helper()
+	var contents []string
+	switch format {
+	case "markdown":
+		return fencedBlocks(rendered)
+	case "xml":
+		var doc xmlContext
+		if err := xml.Unmarshal([]byte(rendered), &doc); err != nil {
+			t.Fatalf("invalid xml: %v", err)
+		}
+		for _, document := range doc.Documents {
+			contents = append(contents, document.Content.Text)
+		}
+	case "json":
+		var output Output
+		if err := json.Unmarshal([]byte(rendered), &output); err != nil {
+			t.Fatalf("invalid json: %v", err)
+		}
+		for _, file := range output.Files {
+			contents = append(contents, file.Content)
+		}
+	case "jsonl":
+		scanner := bufio.NewScanner(strings.NewReader(rendered))
+		for scanner.Scan() {
+			var file FileEntry
+			if err := json.Unmarshal(scanner.Bytes(), &file); err != nil {
+				t.Fatalf("invalid json line %q: %v", scanner.Text(), err)
+			}
+			contents = append(contents, file.Content)
+		}
+	case "txt":
+		separator := strings.Repeat("=", 80)
+		sections := strings.Split(rendered, "\n"+separator+"\n")
+		// Sections alternate between file headers and contents.
+		for i := 2; i < len(sections); i += 2 {
+			contents = append(contents, sections[i])
+		}
+	default:
+		t.Fatalf("no parser for format %s", format)
+	}
+	return contents
+}
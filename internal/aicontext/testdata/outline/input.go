@@ -0,0 +1,63 @@
+// Package shapes draws shapes.
+package shapes
+
+import (
+	"fmt"
+	"math"
+)
+
+// Kind is the kind of a shape.
+type Kind int
+
+// The kinds of shapes; the group exports names, so it is kept whole.
+const (
+	Circle Kind = iota // round
+	square             // unexported, kept for iota
+	Triangle
+)
+
+// limits holds no exported names and is dropped.
+const (
+	maxSides = 12
+	minSides = 3
+)
+
+var (
+	// Default is the default shape.
+	Default = Circle
+	// cache is unexported and dropped with its comment.
+	cache = map[Kind]string{}
+	// Area computes the area of a shape.
+	Area = func(k Kind, r float64) float64 {
+		// Only circles have an area for now.
+		return math.Pi * r * r
+	}
+)
+
+// Shape is a drawable shape.
+type Shape interface {
+	Draw() string
+}
+
+type point struct{ x, y float64 }
+
+// New returns a shape of kind k.
+func New(k Kind) Shape {
+	// A comment in a body is dropped.
+	return circle{}
+}
+
+// helper is unexported and dropped.
+func helper() {}
+
+type circle struct{}
+
+// Draw draws the circle.
+func (circle) Draw() string {
+	return fmt.Sprint("o")
+}
+
+// String names the kind.
+func (k Kind) String() string { return "kind" }
+
+func (c *circle) resize() {}
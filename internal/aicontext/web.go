@@ -0,0 +1,337 @@
+package aicontext
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/base"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/commonmark"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/strikethrough"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/table"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	DefaultUserAgent  = "Mozilla/5.0 (compatible; ai-context; +https://github.com/tanq16/ai-context)"
+	DefaultWebTimeout = 30 * time.Second
+	// maxCrawlPages bounds --crawl-depth on large sites.
+	maxCrawlPages = 200
+	// maxPageBytes is the most read from a single response.
+	maxPageBytes = 20 << 20
+)
+
+var webURLRegex = regexp.MustCompile(`^https?://[^/]+`)
+
+// pageQueryRegex matches the runs of a query string replaced by "_" in page
+// file names.
+var pageQueryRegex = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// skippedLinkExtensions are not followed while crawling.
+var skippedLinkExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".ico": true,
+	".pdf": true, ".zip": true, ".gz": true, ".tgz": true, ".tar": true, ".mp4": true, ".mp3": true,
+	".css": true, ".js": true, ".woff": true, ".woff2": true, ".xml": true, ".json": true,
+}
+
+// pageExtensions are replaced by .md in page file names.
+var pageExtensions = map[string]bool{".html": true, ".htm": true, ".shtml": true, ".php": true, ".asp": true, ".aspx": true}
+
+// boilerplateElements are dropped before conversion.
+var boilerplateElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Nav: true, atom.Header: true,
+	atom.Footer: true, atom.Aside: true, atom.Form: true, atom.Iframe: true, atom.Svg: true,
+	atom.Button: true, atom.Template: true, atom.Dialog: true,
+}
+
+var boilerplateRoles = map[string]bool{
+	"navigation": true, "banner": true, "contentinfo": true, "complementary": true, "search": true,
+}
+
+var markdownConverter = converter.NewConverter(converter.WithPlugins(
+	base.NewBasePlugin(),
+	commonmark.NewCommonmarkPlugin(),
+	table.NewTablePlugin(),
+	strikethrough.NewStrikethroughPlugin(),
+))
+
+type webPage struct {
+	url   *url.URL
+	title string
+	body  string
+	links []*url.URL
+}
+
+func (p *Processor) webClient() *http.Client {
+	timeout := p.config.WebTimeout
+	if timeout <= 0 {
+		timeout = DefaultWebTimeout
+	}
+	return &http.Client{Timeout: timeout, Transport: p.transport}
+}
+
+// ProcessWebURL converts a page, and with --crawl-depth the same-site pages
+// it links to, into Markdown files. Every page becomes one file named after
+// its host and path.
+func (p *Processor) ProcessWebURL(rawURL string) error {
+	start, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+	if err := p.applyConfigFiles(""); err != nil {
+		return err
+	}
+	client := p.webClient()
+	output := &Output{GenerationDate: time.Now().Format(time.RFC3339), Files: make([]FileEntry, 0)}
+	var paths []string
+
+	seen := map[string]bool{pageKey(start): true}
+	queue := []*url.URL{start}
+	for depth := 0; len(queue) > 0 && depth <= p.config.CrawlDepth; depth++ {
+		var next []*url.URL
+		for _, pageURL := range queue {
+			page, err := p.fetchPage(client, pageURL)
+			if err != nil {
+				if pageURL == start {
+					return err
+				}
+				log.Debug().Str("package", "aicontext").Str("url", pageURL.String()).Err(err).Msg("skipping page")
+				continue
+			}
+			for _, link := range page.links {
+				if key := pageKey(link); !seen[key] && len(seen) < maxCrawlPages && link.Host == start.Host {
+					seen[key] = true
+					next = append(next, link)
+				}
+			}
+			entryPath := pagePath(page.url)
+			if !p.filter.shouldIncludePath(entryPath) || !p.filter.shouldIncludeContent([]byte(page.body)) {
+				continue
+			}
+			content := page.body
+			if page.title != "" && !strings.HasPrefix(content, "# ") {
+				content = "# " + page.title + "\n\n" + content
+			}
+			content = fmt.Sprintf("Source: %s\n\n%s\n", page.url, strings.TrimSpace(content))
+			if p.config.MaxSize > 0 && int64(len(content)) > p.config.MaxSize {
+				continue
+			}
+			output.Files = append(output.Files, FileEntry{
+				Path:     entryPath,
+				Content:  content,
+				Language: "markdown",
+				Size:     int64(len(content)),
+				Tokens:   countTokens(content),
+			})
+			paths = append(paths, entryPath)
+		}
+		queue = next
+	}
+
+	output.DirectoryTree = treeFromPaths(paths)
+	output.updateTotals()
+	if err := p.applyTokenBudget(output); err != nil {
+		return fmt.Errorf("failed to apply token budget: %w", err)
+	}
+	return p.writeOutput(output)
+}
+
+func (p *Processor) fetchPage(client *http.Client, pageURL *url.URL) (*webPage, error) {
+	req, err := http.NewRequest(http.MethodGet, pageURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := p.config.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9,*/*;q=0.5")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", pageURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", pageURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", pageURL, err)
+	}
+	// Links are resolved against the URL after redirects.
+	page := &webPage{url: resp.Request.URL}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml" || (mediaType == "" && isHTML(body)):
+		return page, p.convertPage(client, page, body)
+	case strings.HasPrefix(mediaType, "text/"):
+		page.body = string(body)
+		return page, nil
+	}
+	return nil, fmt.Errorf("unsupported content type %q at %s", mediaType, pageURL)
+}
+
+func isHTML(body []byte) bool {
+	head := bytes.ToLower(body[:min(len(body), 512)])
+	return bytes.Contains(head, []byte("<html")) || bytes.Contains(head, []byte("<!doctype html"))
+}
+
+// convertPage collects the links and title of an HTML page, strips
+// navigation and other boilerplate and converts the main content to
+// Markdown, with links made absolute and images handled per --images.
+func (p *Processor) convertPage(client *http.Client, page *webPage, body []byte) error {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to parse html: %w", err)
+	}
+	base := page.url
+	walkHTML(doc, func(n *html.Node) bool {
+		switch n.DataAtom {
+		case atom.Base:
+			if href := htmlAttr(n, "href"); href != "" {
+				if resolved, err := page.url.Parse(href); err == nil {
+					base = resolved
+				}
+			}
+		case atom.Title:
+			if page.title == "" && n.FirstChild != nil {
+				page.title = strings.TrimSpace(n.FirstChild.Data)
+			}
+		case atom.A:
+			if link := crawlableLink(base, htmlAttr(n, "href")); link != nil {
+				page.links = append(page.links, link)
+			}
+		}
+		return true
+	})
+
+	walkHTML(doc, func(n *html.Node) bool {
+		for child := n.FirstChild; child != nil; {
+			next := child.NextSibling
+			if isBoilerplate(child) {
+				n.RemoveChild(child)
+			}
+			child = next
+		}
+		return true
+	})
+	content := mainContent(doc)
+	walkHTML(content, func(n *html.Node) bool {
+		if href := htmlAttr(n, "href"); n.DataAtom == atom.A && href != "" {
+			if resolved, err := base.Parse(href); err == nil {
+				setHTMLAttr(n, "href", resolved.String())
+			}
+		}
+		return true
+	})
+	p.rewriteImages(client, content, base)
+	markdown, err := markdownConverter.ConvertNode(content)
+	if err != nil {
+		return fmt.Errorf("failed to convert html: %w", err)
+	}
+	page.body = string(markdown)
+	return nil
+}
+
+// mainContent picks the element holding the page's content: <main>, a
+// role="main" element, the only <article>, or else <body>.
+func mainContent(doc *html.Node) *html.Node {
+	var main, body *html.Node
+	var articles []*html.Node
+	walkHTML(doc, func(n *html.Node) bool {
+		switch {
+		case main == nil && (n.DataAtom == atom.Main || htmlAttr(n, "role") == "main"):
+			main = n
+		case n.DataAtom == atom.Article:
+			articles = append(articles, n)
+		case n.DataAtom == atom.Body:
+			body = n
+		}
+		return true
+	})
+	switch {
+	case main != nil:
+		return main
+	case len(articles) == 1:
+		return articles[0]
+	case body != nil:
+		return body
+	}
+	return doc
+}
+
+func isBoilerplate(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return n.Type == html.CommentNode
+	}
+	return boilerplateElements[n.DataAtom] || boilerplateRoles[htmlAttr(n, "role")] || htmlAttr(n, "aria-hidden") == "true"
+}
+
+// walkHTML visits n and its descendants in document order while visit
+// returns true.
+func walkHTML(n *html.Node, visit func(*html.Node) bool) {
+	if !visit(n) {
+		return
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		walkHTML(child, visit)
+	}
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// crawlableLink resolves href and returns it when it is an http(s) page
+// rather than an anchor on the same page or a binary asset.
+func crawlableLink(base *url.URL, href string) *url.URL {
+	if href == "" || strings.HasPrefix(href, "#") {
+		return nil
+	}
+	link, err := base.Parse(href)
+	if err != nil || (link.Scheme != "http" && link.Scheme != "https") {
+		return nil
+	}
+	link.Fragment = ""
+	if skippedLinkExtensions[strings.ToLower(path.Ext(link.Path))] {
+		return nil
+	}
+	return link
+}
+
+func pageKey(u *url.URL) string {
+	return u.Host + strings.TrimSuffix(u.Path, "/") + "?" + u.RawQuery
+}
+
+// pagePath names a page's file after its host and path, e.g.
+// docs.example.com/guide/install.md.
+func pagePath(u *url.URL) string {
+	name := strings.Trim(u.Path, "/")
+	if name == "" {
+		name = "index"
+	}
+	if u.RawQuery != "" {
+		name += "_" + pageQueryRegex.ReplaceAllString(u.RawQuery, "_")
+	}
+	if ext := path.Ext(name); pageExtensions[strings.ToLower(ext)] {
+		name = strings.TrimSuffix(name, ext)
+	}
+	name += ".md"
+	return u.Host + "/" + name
+}
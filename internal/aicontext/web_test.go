@@ -0,0 +1,138 @@
+package aicontext
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestProcessWebURLCrawl(t *testing.T) {
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("crawled other site: %s", r.URL)
+	}))
+	defer external.Close()
+
+	var mu sync.Mutex
+	var requested []string
+	pages := map[string]string{
+		"/": `<!doctype html><html><head><title>Home</title><script>var tracking = 1;</script></head><body>
+			<header>Site banner</header>
+			<nav><a href="/docs/">Docs</a></nav>
+			<main>
+				<h1>Welcome</h1>
+				<p>Read the <a href="docs/guide.html#install">guide</a> or <a href="` + external.URL + `/elsewhere">elsewhere</a>.</p>
+				<p><a href="#top">Top</a> <a href="/logo.png">Logo</a> <a href="mailto:team@example.com">Mail</a></p>
+				<div role="navigation">Breadcrumbs</div>
+			</main>
+			<aside>Related posts</aside>
+			<footer>Copyright</footer>
+		</body></html>`,
+		"/docs/": `<html><head><title>Docs</title></head><body><article><p>Docs index.</p></article></body></html>`,
+		"/docs/guide.html": `<html><head><title>Guide</title></head><body>
+			<p>Install it, then see <a href="/docs/deep?page=2&sort=asc">more</a>.</p>
+			<form><button>Subscribe</button></form>
+		</body></html>`,
+		"/docs/deep": "Plain text page.",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.RequestURI())
+		mu.Unlock()
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasPrefix(body, "<") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "text/plain")
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		depth     int
+		wantPaths []string
+	}{
+		{0, []string{host + "/index.md"}},
+		{1, []string{host + "/index.md", host + "/docs.md", host + "/docs/guide.md"}},
+		{2, []string{host + "/index.md", host + "/docs.md", host + "/docs/guide.md", host + "/docs/deep_page_2_sort_asc.md"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("depth %d", tt.depth), func(t *testing.T) {
+			mu.Lock()
+			requested = nil
+			mu.Unlock()
+			p := NewProcessor(ProcessorConfig{CrawlDepth: tt.depth})
+			output := processToJSON(t, p, func() error { return p.ProcessWebURL(server.URL + "/") })
+			var paths []string
+			for _, file := range output.Files {
+				paths = append(paths, file.Path)
+			}
+			if !slices.Equal(paths, tt.wantPaths) {
+				t.Errorf("got pages %v, want %v", paths, tt.wantPaths)
+			}
+			mu.Lock()
+			if len(requested) != len(tt.wantPaths) {
+				t.Errorf("requested %v, want one request per page", requested)
+			}
+			mu.Unlock()
+
+			index := output.Files[0].Content
+			for _, want := range []string{
+				"Source: " + server.URL + "/\n\n# Welcome\n",
+				"[guide](" + server.URL + "/docs/guide.html#install)",
+			} {
+				if !strings.Contains(index, want) {
+					t.Errorf("index page lacks %q:\n%s", want, index)
+				}
+			}
+			for _, boilerplate := range []string{"tracking", "Site banner", "Docs", "Breadcrumbs", "Related posts", "Copyright"} {
+				if strings.Contains(index, boilerplate) {
+					t.Errorf("index page keeps boilerplate %q:\n%s", boilerplate, index)
+				}
+			}
+			// Links are followed in document order, including those in the
+			// stripped navigation.
+			if tt.depth > 0 {
+				guide := output.Files[2].Content
+				if !strings.HasPrefix(guide, "Source: "+server.URL+"/docs/guide.html\n\n# Guide\n\nInstall it") || strings.Contains(guide, "Subscribe") {
+					t.Errorf("unexpected guide page:\n%s", guide)
+				}
+			}
+		})
+	}
+}
+
+func TestPagePath(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com", "example.com/index.md"},
+		{"https://example.com/", "example.com/index.md"},
+		{"https://example.com/docs/", "example.com/docs.md"},
+		{"https://example.com/docs/install.html", "example.com/docs/install.md"},
+		{"https://example.com/a/b.PHP", "example.com/a/b.md"},
+		{"https://example.com/notes.txt", "example.com/notes.txt.md"},
+		{"https://example.com/search?q=go+lang&page=2", "example.com/search_q_go_lang_page_2.md"},
+		{"https://example.com/?id=7", "example.com/index_id_7.md"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pagePath(u); got != tt.want {
+			t.Errorf("pagePath(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package aicontext
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCaptionLanguage is the --lang used when none is given.
+const DefaultCaptionLanguage = "en"
+
+const (
+	youtubeBaseURL = "https://www.youtube.com"
+	// The Android client returns caption URLs that work without the proof
+	// of origin tokens the web player now requires.
+	innertubeClientName    = "ANDROID"
+	innertubeClientVersion = "20.10.38"
+	innertubeUserAgent     = "com.google.android.youtube/20.10.38 (Linux; U; Android 14) gzip"
+)
+
+var (
+	youtubeHostRegex = regexp.MustCompile(`^((www|m|music)\.)?(youtube\.com|youtube-nocookie\.com)$`)
+	videoIDRegex     = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	apiKeyRegex      = regexp.MustCompile(`"INNERTUBE_API_KEY":\s*"([A-Za-z0-9_-]+)"`)
+	// A chapter is a description line starting or ending with a timestamp,
+	// like "0:00 Intro" or "Intro - 1:02:03".
+	chapterStartRegex = regexp.MustCompile(`^\W*?((?:\d+:)?\d{1,2}:\d{2})\W+(.+)$`)
+	chapterEndRegex   = regexp.MustCompile(`^(.+?)\W+\(?((?:\d+:)?\d{1,2}:\d{2})\)?$`)
+)
+
+// youtubeVideoPrefixes are the path prefixes of video URLs other than
+// /watch?v=, e.g. /shorts/<id>.
+var youtubeVideoPrefixes = map[string]bool{"shorts": true, "embed": true, "live": true, "v": true, "e": true}
+
+type ytPlayerResponse struct {
+	PlayabilityStatus struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	} `json:"playabilityStatus"`
+	VideoDetails struct {
+		VideoID          string `json:"videoId"`
+		Title            string `json:"title"`
+		Author           string `json:"author"`
+		LengthSeconds    string `json:"lengthSeconds"`
+		ShortDescription string `json:"shortDescription"`
+	} `json:"videoDetails"`
+	Captions struct {
+		Renderer struct {
+			CaptionTracks []ytCaptionTrack `json:"captionTracks"`
+		} `json:"playerCaptionsTracklistRenderer"`
+	} `json:"captions"`
+}
+
+type ytCaptionTrack struct {
+	BaseURL string `json:"baseUrl"`
+	Name    struct {
+		SimpleText string `json:"simpleText"`
+		Runs       []struct {
+			Text string `json:"text"`
+		} `json:"runs"`
+	} `json:"name"`
+	LanguageCode   string `json:"languageCode"`
+	Kind           string `json:"kind"`
+	IsTranslatable bool   `json:"isTranslatable"`
+}
+
+func (t ytCaptionTrack) auto() bool {
+	return t.Kind == "asr"
+}
+
+func (t ytCaptionTrack) label() string {
+	name := t.Name.SimpleText
+	for _, run := range t.Name.Runs {
+		name += run.Text
+	}
+	if name == "" {
+		name = t.LanguageCode
+	}
+	return name
+}
+
+// ytTimedText is the json3 caption format.
+type ytTimedText struct {
+	Events []struct {
+		StartMs int64 `json:"tStartMs"`
+		Segs    []struct {
+			UTF8 string `json:"utf8"`
+		} `json:"segs"`
+	} `json:"events"`
+}
+
+type chapter struct {
+	start time.Duration
+	title string
+}
+
+// youtubeVideoID returns the video ID of watch, youtu.be, shorts, embed and
+// live URLs.
+func youtubeVideoID(rawURL string) (string, bool) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return "", false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	parts := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	var id string
+	switch {
+	case host == "youtu.be":
+		id = parts[0]
+	case !youtubeHostRegex.MatchString(host):
+		return "", false
+	case parsed.Path == "/watch":
+		id = parsed.Query().Get("v")
+	case len(parts) >= 2 && youtubeVideoPrefixes[parts[0]]:
+		id = parts[1]
+	}
+	return id, videoIDRegex.MatchString(id)
+}
+
+// ProcessYouTubeURL writes the transcript of a video with its title,
+// description and chapters. Manual captions are preferred over
+// auto-generated ones, and captions in another language are translated to
+// --lang when neither exists.
+func (p *Processor) ProcessYouTubeURL(rawURL string) error {
+	videoID, ok := youtubeVideoID(rawURL)
+	if !ok {
+		return fmt.Errorf("not a youtube video url: %s", rawURL)
+	}
+	if err := p.applyConfigFiles(""); err != nil {
+		return err
+	}
+	client := p.webClient()
+	player, err := p.youtubePlayer(client, videoID)
+	if err != nil {
+		return err
+	}
+	lang := p.config.CaptionLanguage
+	if lang == "" {
+		lang = DefaultCaptionLanguage
+	}
+	track, translate, err := selectCaptionTrack(player.Captions.Renderer.CaptionTracks, lang)
+	if err != nil {
+		return fmt.Errorf("video %s: %w", videoID, err)
+	}
+	captions, err := p.youtubeCaptions(client, track, translate)
+	if err != nil {
+		return err
+	}
+
+	details := player.VideoDetails
+	var content strings.Builder
+	fmt.Fprintf(&content, "# %s\n\n", details.Title)
+	fmt.Fprintf(&content, "Source: %s/watch?v=%s\n", youtubeBaseURL, videoID)
+	if details.Author != "" {
+		fmt.Fprintf(&content, "Channel: %s\n", details.Author)
+	}
+	if seconds, err := strconv.Atoi(details.LengthSeconds); err == nil && seconds > 0 {
+		fmt.Fprintf(&content, "Duration: %s\n", formatTimestamp(time.Duration(seconds)*time.Second))
+	}
+	captionKind := ""
+	switch {
+	case translate != "":
+		captionKind = ", translated to " + translate
+	case track.auto():
+		captionKind = ", auto-generated"
+	}
+	fmt.Fprintf(&content, "Captions: %s (%s%s)\n", track.label(), track.LanguageCode, captionKind)
+	if description := strings.TrimSpace(details.ShortDescription); description != "" {
+		fmt.Fprintf(&content, "\n## Description\n\n%s\n", description)
+	}
+	content.WriteString("\n## Transcript\n")
+	writeTranscript(&content, captions, parseChapters(details.ShortDescription))
+
+	entryPath := videoID + ".md"
+	output := &Output{GenerationDate: time.Now().Format(time.RFC3339), Files: make([]FileEntry, 0)}
+	text := content.String()
+	if p.filter.shouldIncludePath(entryPath) && p.filter.shouldIncludeContent([]byte(text)) {
+		output.Files = append(output.Files, FileEntry{
+			Path:     entryPath,
+			Content:  text,
+			Language: "markdown",
+			Size:     int64(len(text)),
+			Tokens:   countTokens(text),
+		})
+		output.DirectoryTree = treeFromPaths([]string{entryPath})
+	}
+	output.updateTotals()
+	if err := p.applyTokenBudget(output); err != nil {
+		return fmt.Errorf("failed to apply token budget: %w", err)
+	}
+	return p.writeOutput(output)
+}
+
+// youtubePlayer reads the API key from the watch page and asks the
+// innertube player endpoint for the video's details and caption tracks.
+func (p *Processor) youtubePlayer(client *http.Client, videoID string) (*ytPlayerResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, youtubeBaseURL+"/watch?v="+videoID, nil)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := p.config.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	// Skip the cookie consent interstitial shown in the EU.
+	req.Header.Set("Cookie", "CONSENT=YES+cb; SOCS=CAI")
+	page, err := youtubeRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	match := apiKeyRegex.FindSubmatch(page)
+	if match == nil {
+		if bytes.Contains(page, []byte("g-recaptcha")) {
+			return nil, fmt.Errorf("youtube is rate limiting requests, try again later")
+		}
+		return nil, fmt.Errorf("failed to read the watch page of video %s", videoID)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"context": map[string]any{"client": map[string]string{
+			"clientName":    innertubeClientName,
+			"clientVersion": innertubeClientVersion,
+		}},
+		"videoId": videoID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err = http.NewRequest(http.MethodPost, youtubeBaseURL+"/youtubei/v1/player?key="+url.QueryEscape(string(match[1])), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", innertubeUserAgent)
+	data, err := youtubeRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	var player ytPlayerResponse
+	if err := json.Unmarshal(data, &player); err != nil {
+		return nil, fmt.Errorf("failed to decode player response: %w", err)
+	}
+	if status := player.PlayabilityStatus; status.Status != "OK" {
+		reason := status.Reason
+		if reason == "" {
+			reason = strings.ToLower(status.Status)
+		}
+		return nil, fmt.Errorf("video %s is not available: %s", videoID, reason)
+	}
+	return &player, nil
+}
+
+func (p *Processor) youtubeCaptions(client *http.Client, track ytCaptionTrack, translate string) (*ytTimedText, error) {
+	captionURL, err := url.Parse(track.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid caption url: %w", err)
+	}
+	query := captionURL.Query()
+	query.Set("fmt", "json3")
+	if translate != "" {
+		query.Set("tlang", translate)
+	}
+	captionURL.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, captionURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", innertubeUserAgent)
+	data, err := youtubeRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("youtube returned empty captions")
+	}
+	var captions ytTimedText
+	if err := json.Unmarshal(data, &captions); err != nil {
+		return nil, fmt.Errorf("failed to decode captions: %w", err)
+	}
+	return &captions, nil
+}
+
+func youtubeRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", req.URL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, fmt.Errorf("youtube is rate limiting requests, try again later")
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("failed to fetch %s%s: %s", req.URL.Host, req.URL.Path, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxPageBytes))
+}
+
+// selectCaptionTrack picks a manual track in lang, then an auto-generated
+// one, matching region variants like en-GB for "en". Failing that, a
+// translatable track is returned with lang as the translation target.
+func selectCaptionTrack(tracks []ytCaptionTrack, lang string) (ytCaptionTrack, string, error) {
+	if len(tracks) == 0 {
+		return ytCaptionTrack{}, "", fmt.Errorf("video has no captions")
+	}
+	base, _, _ := strings.Cut(strings.ToLower(lang), "-")
+	for _, auto := range []bool{false, true} {
+		for _, exact := range []bool{true, false} {
+			for _, track := range tracks {
+				code := strings.ToLower(track.LanguageCode)
+				trackBase, _, _ := strings.Cut(code, "-")
+				if track.auto() == auto && (code == strings.ToLower(lang) || (!exact && trackBase == base)) {
+					return track, "", nil
+				}
+			}
+		}
+	}
+	for _, auto := range []bool{false, true} {
+		for _, track := range tracks {
+			if track.auto() == auto && track.IsTranslatable {
+				return track, lang, nil
+			}
+		}
+	}
+	available := make([]string, len(tracks))
+	for i, track := range tracks {
+		available[i] = track.LanguageCode
+		if track.auto() {
+			available[i] += " (auto-generated)"
+		}
+	}
+	return ytCaptionTrack{}, "", fmt.Errorf("no %s captions, available: %s", lang, strings.Join(available, ", "))
+}
+
+// parseChapters reads chapter markers from a description the way YouTube
+// does: timestamped lines, the first at 0:00, in ascending order.
+func parseChapters(description string) []chapter {
+	var chapters []chapter
+	for _, line := range strings.Split(description, "\n") {
+		line = strings.TrimSpace(line)
+		var stamp, title string
+		if match := chapterStartRegex.FindStringSubmatch(line); match != nil {
+			stamp, title = match[1], match[2]
+		} else if match := chapterEndRegex.FindStringSubmatch(line); match != nil {
+			stamp, title = match[2], match[1]
+		} else {
+			continue
+		}
+		start, ok := parseTimestamp(stamp)
+		if !ok || (len(chapters) == 0 && start != 0) || (len(chapters) > 0 && start <= chapters[len(chapters)-1].start) {
+			continue
+		}
+		chapters = append(chapters, chapter{start: start, title: strings.TrimSpace(title)})
+	}
+	if len(chapters) < 2 {
+		return nil
+	}
+	return chapters
+}
+
+func parseTimestamp(stamp string) (time.Duration, bool) {
+	var total time.Duration
+	for _, part := range strings.Split(stamp, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, false
+		}
+		total = total*60 + time.Duration(n)
+	}
+	return total * time.Second, true
+}
+
+// formatTimestamp formats d as m:ss, or h:mm:ss for an hour or more.
+func formatTimestamp(d time.Duration) string {
+	seconds := int(d / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// writeTranscript writes one "[m:ss] text" line per caption with a heading
+// at the start of every chapter.
+func writeTranscript(w *strings.Builder, captions *ytTimedText, chapters []chapter) {
+	if len(chapters) == 0 {
+		w.WriteString("\n")
+	}
+	next := 0
+	for _, event := range captions.Events {
+		var text strings.Builder
+		for _, seg := range event.Segs {
+			text.WriteString(seg.UTF8)
+		}
+		line := strings.Join(strings.Fields(text.String()), " ")
+		if line == "" {
+			continue
+		}
+		start := time.Duration(event.StartMs) * time.Millisecond
+		for next < len(chapters) && chapters[next].start <= start {
+			fmt.Fprintf(w, "\n### [%s] %s\n\n", formatTimestamp(chapters[next].start), chapters[next].title)
+			next++
+		}
+		fmt.Fprintf(w, "[%s] %s\n", formatTimestamp(start), line)
+	}
+}
@@ -0,0 +1,217 @@
+package aicontext
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc serves requests without a network.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func textResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+// fakeYouTube serves the watch page, the player endpoint and json3
+// captions for video dQw4w9WgXcQ, and records the caption request.
+func fakeYouTube(t *testing.T, captionQuery *string) http.RoundTripper {
+	player := map[string]any{
+		"playabilityStatus": map[string]any{"status": "OK"},
+		"videoDetails": map[string]any{
+			"videoId":          "dQw4w9WgXcQ",
+			"title":            "Talk",
+			"author":           "Channel",
+			"lengthSeconds":    "3725",
+			"shortDescription": "About the talk.\n\n0:00 Intro\n1:00 Main part\n",
+		},
+		"captions": map[string]any{"playerCaptionsTracklistRenderer": map[string]any{"captionTracks": []map[string]any{
+			{"baseUrl": "https://www.youtube.com/api/timedtext?v=dQw4w9WgXcQ&lang=en", "languageCode": "en", "kind": "asr", "isTranslatable": true, "name": map[string]any{"runs": []map[string]any{{"text": "English (auto-generated)"}}}},
+			{"baseUrl": "https://www.youtube.com/api/timedtext?v=dQw4w9WgXcQ&lang=en-GB", "languageCode": "en-GB", "isTranslatable": true, "name": map[string]any{"simpleText": "English (United Kingdom)"}},
+		}}},
+	}
+	captions := `{"events": [
+		{"tStartMs": 0, "segs": [{"utf8": "Hello"}, {"utf8": " everyone"}]},
+		{"tStartMs": 30000, "segs": [{"utf8": "\n"}]},
+		{"tStartMs": 61500, "segs": [{"utf8": "Now the  main\npart"}]}
+	]}`
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch {
+		case req.URL.Host != "www.youtube.com":
+			t.Errorf("unexpected host in %s", req.URL)
+		case req.Method == http.MethodGet && req.URL.Path == "/watch":
+			if req.URL.Query().Get("v") != "dQw4w9WgXcQ" {
+				t.Errorf("unexpected watch page %s", req.URL)
+			}
+			return textResponse(req, http.StatusOK, `<script>ytcfg.set({"INNERTUBE_API_KEY": "key-123"});</script>`), nil
+		case req.Method == http.MethodPost && req.URL.Path == "/youtubei/v1/player":
+			var body struct {
+				Context struct {
+					Client struct {
+						ClientName string `json:"clientName"`
+					} `json:"client"`
+				} `json:"context"`
+				VideoID string `json:"videoId"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil || req.URL.Query().Get("key") != "key-123" || body.VideoID != "dQw4w9WgXcQ" || body.Context.Client.ClientName != innertubeClientName {
+				t.Errorf("unexpected player request %s: %+v", req.URL, body)
+			}
+			data, _ := json.Marshal(player)
+			return textResponse(req, http.StatusOK, string(data)), nil
+		case req.Method == http.MethodGet && req.URL.Path == "/api/timedtext":
+			*captionQuery = req.URL.RawQuery
+			return textResponse(req, http.StatusOK, captions), nil
+		}
+		return textResponse(req, http.StatusNotFound, ""), nil
+	})
+}
+
+func TestProcessYouTubeURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		lang        string
+		wantQuery   string
+		wantCaption string
+	}{
+		{"manual region variant", "en", "fmt=json3&lang=en-GB&v=dQw4w9WgXcQ", "Captions: English (United Kingdom) (en-GB)\n"},
+		{"translated", "de", "fmt=json3&lang=en-GB&tlang=de&v=dQw4w9WgXcQ", "Captions: English (United Kingdom) (en-GB, translated to de)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var captionQuery string
+			p := NewProcessor(ProcessorConfig{CaptionLanguage: tt.lang})
+			p.transport = fakeYouTube(t, &captionQuery)
+			output := processToJSON(t, p, func() error { return p.ProcessYouTubeURL("https://youtu.be/dQw4w9WgXcQ?t=42") })
+			if captionQuery != tt.wantQuery {
+				t.Errorf("caption query = %q, want %q", captionQuery, tt.wantQuery)
+			}
+			if len(output.Files) != 1 || output.Files[0].Path != "dQw4w9WgXcQ.md" {
+				t.Fatalf("unexpected files: %+v", output.Files)
+			}
+			content := output.Files[0].Content
+			for _, want := range []string{
+				"# Talk\n\nSource: https://www.youtube.com/watch?v=dQw4w9WgXcQ\nChannel: Channel\nDuration: 1:02:05\n",
+				tt.wantCaption,
+				"\n## Description\n\nAbout the talk.\n",
+				"\n### [0:00] Intro\n\n[0:00] Hello everyone\n",
+				"\n### [1:00] Main part\n\n[1:01] Now the main part\n",
+			} {
+				if !strings.Contains(content, want) {
+					t.Errorf("transcript lacks %q:\n%s", want, content)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectCaptionTrack(t *testing.T) {
+	manual := func(code string, translatable bool) ytCaptionTrack {
+		return ytCaptionTrack{LanguageCode: code, IsTranslatable: translatable}
+	}
+	auto := func(code string, translatable bool) ytCaptionTrack {
+		return ytCaptionTrack{LanguageCode: code, Kind: "asr", IsTranslatable: translatable}
+	}
+	tests := []struct {
+		name          string
+		tracks        []ytCaptionTrack
+		lang          string
+		wantCode      string
+		wantAuto      bool
+		wantTranslate string
+		wantErr       bool
+	}{
+		{"manual before auto", []ytCaptionTrack{auto("en", true), manual("en", true)}, "en", "en", false, "", false},
+		{"manual variant before auto exact", []ytCaptionTrack{auto("en", true), manual("en-US", true)}, "en", "en-US", false, "", false},
+		{"exact before variant", []ytCaptionTrack{manual("pt-PT", false), manual("pt-BR", false)}, "pt-BR", "pt-BR", false, "", false},
+		{"variant of requested region", []ytCaptionTrack{manual("pt-PT", false)}, "pt-BR", "pt-PT", false, "", false},
+		{"case insensitive", []ytCaptionTrack{manual("zh-Hans", false)}, "ZH-hans", "zh-Hans", false, "", false},
+		{"auto when no manual", []ytCaptionTrack{manual("fr", false), auto("en", false)}, "en", "en", true, "", false},
+		{"translate manual first", []ytCaptionTrack{auto("ja", true), manual("fr", true)}, "de", "fr", false, "de", false},
+		{"translate auto", []ytCaptionTrack{manual("fr", false), auto("ja", true)}, "de", "ja", true, "de", false},
+		{"nothing translatable", []ytCaptionTrack{manual("fr", false)}, "de", "", false, "", true},
+		{"no captions", nil, "en", "", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track, translate, err := selectCaptionTrack(tt.tracks, tt.lang)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got track %+v, want an error", track)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if track.LanguageCode != tt.wantCode || track.auto() != tt.wantAuto || translate != tt.wantTranslate {
+				t.Errorf("got %s (auto %v, translate %q), want %s (auto %v, translate %q)",
+					track.LanguageCode, track.auto(), translate, tt.wantCode, tt.wantAuto, tt.wantTranslate)
+			}
+		})
+	}
+}
+
+func TestParseChapters(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		want        []chapter
+	}{
+		{"leading timestamps", "Intro text\n0:00 Intro\n2:30 - Setup\n1:02:03 Wrap up", []chapter{
+			{0, "Intro"}, {150 * time.Second, "Setup"}, {time.Hour + 2*time.Minute + 3*time.Second, "Wrap up"},
+		}},
+		{"trailing timestamps", "Intro - 0:00\nDemo (4:05)", []chapter{{0, "Intro"}, {245 * time.Second, "Demo"}}},
+		{"must start at zero", "0:10 Intro\n1:00 Demo", nil},
+		{"out of order lines skipped", "0:00 Intro\n5:00 Demo\n3:00 Aside\n6:00 End", []chapter{
+			{0, "Intro"}, {5 * time.Minute, "Demo"}, {6 * time.Minute, "End"},
+		}},
+		{"single chapter", "0:00 Intro", nil},
+		{"no timestamps", "Just a description.", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseChapters(tt.description); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseChapters() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYouTubeVideoID(t *testing.T) {
+	tests := []struct {
+		url    string
+		wantID string
+		wantOK bool
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=10s", "dQw4w9WgXcQ", true},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://youtu.be/dQw4w9WgXcQ?si=abc", "dQw4w9WgXcQ", true},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://youtube.com/live/dQw4w9WgXcQ?feature=share", "dQw4w9WgXcQ", true},
+		{"https://www.youtube.com/channel/UCabcdefghijk", "", false},
+		{"https://www.youtube.com/watch?v=short", "short", false},
+		{"https://example.com/watch?v=dQw4w9WgXcQ", "", false},
+		{"ftp://youtu.be/dQw4w9WgXcQ", "", false},
+	}
+	for _, tt := range tests {
+		id, ok := youtubeVideoID(tt.url)
+		if ok != tt.wantOK || (ok && id != tt.wantID) {
+			t.Errorf("youtubeVideoID(%q) = %q, %v, want %q, %v", tt.url, id, ok, tt.wantID, tt.wantOK)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -16,13 +17,25 @@ var (
 	warnStyle    = lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(11)) // bright yellow
 )
 
+// output receives all status messages. It is switched to stderr when the
+// generated context is written to stdout.
+var output io.Writer = os.Stdout
+
+func SetOutput(w io.Writer) {
+	output = w
+}
+
+func Output() io.Writer {
+	return output
+}
+
 func PrintInfo(msg string) {
 	if GlobalDebugFlag {
 		log.Info().Msg(msg)
 	} else if GlobalForAIFlag {
-		fmt.Println("[INFO] " + msg)
+		fmt.Fprintln(output, "[INFO] " + msg)
 	} else {
-		fmt.Println(infoStyle.Render("→ " + msg))
+		fmt.Fprintln(output, infoStyle.Render("→ " + msg))
 	}
 }
 
@@ -30,9 +43,9 @@ func PrintSuccess(msg string) {
 	if GlobalDebugFlag {
 		log.Info().Msg(msg)
 	} else if GlobalForAIFlag {
-		fmt.Println("[OK] " + msg)
+		fmt.Fprintln(output, "[OK] " + msg)
 	} else {
-		fmt.Println(successStyle.Render("✓ " + msg))
+		fmt.Fprintln(output, successStyle.Render("✓ " + msg))
 	}
 }
 
@@ -44,9 +57,9 @@ func PrintError(msg string, err error) {
 			log.Error().Msg(msg)
 		}
 	} else if GlobalForAIFlag {
-		fmt.Println("[ERROR] " + msg)
+		fmt.Fprintln(output, "[ERROR] " + msg)
 	} else {
-		fmt.Println(errorStyle.Render("✗ " + msg))
+		fmt.Fprintln(output, errorStyle.Render("✗ " + msg))
 	}
 }
 
@@ -58,9 +71,9 @@ func PrintFatal(msg string, err error) {
 			log.Error().Msg(msg)
 		}
 	} else if GlobalForAIFlag {
-		fmt.Println("[ERROR] " + msg)
+		fmt.Fprintln(output, "[ERROR] " + msg)
 	} else {
-		fmt.Println(errorStyle.Render("✗ " + msg))
+		fmt.Fprintln(output, errorStyle.Render("✗ " + msg))
 	}
 	os.Exit(1)
 }
@@ -73,23 +86,23 @@ func PrintWarn(msg string, err error) {
 			log.Warn().Msg(msg)
 		}
 	} else if GlobalForAIFlag {
-		fmt.Println("[WARN] " + msg)
+		fmt.Fprintln(output, "[WARN] " + msg)
 	} else {
-		fmt.Println(warnStyle.Render("! " + msg))
+		fmt.Fprintln(output, warnStyle.Render("! " + msg))
 	}
 }
 
 func PrintGeneric(msg string) {
-	fmt.Println(msg)
+	fmt.Fprintln(output, msg)
 }
 
 func PrintRunning(msg string) {
 	if GlobalDebugFlag {
 		log.Info().Str("package", "utils").Msg(msg)
 	} else if GlobalForAIFlag {
-		fmt.Println("[RUNNING] " + msg)
+		fmt.Fprintln(output, "[RUNNING] " + msg)
 	} else {
-		fmt.Println(infoStyle.Render("↻ " + msg))
+		fmt.Fprintln(output, infoStyle.Render("↻ " + msg))
 	}
 }
 
@@ -97,9 +110,9 @@ func PrintIndentedSuccess(msg string) {
 	if GlobalDebugFlag {
 		log.Info().Str("package", "utils").Msg(msg)
 	} else if GlobalForAIFlag {
-		fmt.Println("[OK] " + msg)
+		fmt.Fprintln(output, "[OK] " + msg)
 	} else {
-		fmt.Println(successStyle.Render("  ✓ " + msg))
+		fmt.Fprintln(output, successStyle.Render("  ✓ " + msg))
 	}
 }
 
@@ -111,9 +124,9 @@ func PrintIndentedError(msg string, err error) {
 			log.Error().Str("package", "utils").Msg(msg)
 		}
 	} else if GlobalForAIFlag {
-		fmt.Println("[ERROR] " + msg)
+		fmt.Fprintln(output, "[ERROR] " + msg)
 	} else {
-		fmt.Println(errorStyle.Render("  ✗ " + msg))
+		fmt.Fprintln(output, errorStyle.Render("  ✗ " + msg))
 	}
 }
 
@@ -125,9 +138,9 @@ func PrintIndentedWarn(msg string, err error) {
 			log.Warn().Str("package", "utils").Msg(msg)
 		}
 	} else if GlobalForAIFlag {
-		fmt.Println("[WARN] " + msg)
+		fmt.Fprintln(output, "[WARN] " + msg)
 	} else {
-		fmt.Println(warnStyle.Render("  ! " + msg))
+		fmt.Fprintln(output, warnStyle.Render("  ! " + msg))
 	}
 }
 
@@ -135,9 +148,9 @@ func PrintIndentedRunning(msg string) {
 	if GlobalDebugFlag {
 		log.Info().Str("package", "utils").Msg(msg)
 	} else if GlobalForAIFlag {
-		fmt.Println("[RUNNING] " + msg)
+		fmt.Fprintln(output, "[RUNNING] " + msg)
 	} else {
-		fmt.Println(infoStyle.Render("  ↻ " + msg))
+		fmt.Fprintln(output, infoStyle.Render("  ↻ " + msg))
 	}
 }
 
@@ -146,7 +159,7 @@ func ClearLines(n int) {
 		return
 	}
 	for i := 0; i < n; i++ {
-		fmt.Print("\033[A\033[2K")
+		fmt.Fprint(output, "\033[A\033[2K")
 	}
 }
 
@@ -154,7 +167,7 @@ func ClearPreviousLine() {
 	if GlobalDebugFlag || GlobalForAIFlag {
 		return
 	}
-	fmt.Print("\033[A\033[2K")
+	fmt.Fprint(output, "\033[A\033[2K")
 }
 
 func PrintProgress(label string, percent int) {
@@ -168,7 +181,7 @@ func PrintProgress(label string, percent int) {
 	}
 
 	if GlobalForAIFlag {
-		fmt.Printf("[PROGRESS] %s: %d%%\n", label, percent)
+		fmt.Fprintf(output, "[PROGRESS] %s: %d%%\n", label, percent)
 		return
 	}
 
@@ -177,5 +190,5 @@ func PrintProgress(label string, percent int) {
 	empty := barWidth - filled
 
 	bar := strings.Repeat("⣿", filled) + strings.Repeat("⣀", empty)
-	fmt.Println(infoStyle.Render(fmt.Sprintf("  ↻ %s: %s %d%%", label, bar, percent)))
+	fmt.Fprintln(output, infoStyle.Render(fmt.Sprintf("  ↻ %s: %s %d%%", label, bar, percent)))
 }
\ No newline at end of file